@@ -0,0 +1,175 @@
+package moesifawslambda
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// Flatten the headers of an ALB event; multi-value headers are used when the target group enables them
+func albHeaders(headers map[string]string, multiValueHeaders map[string][]string) map[string]string {
+	if len(multiValueHeaders) == 0 {
+		return headers
+	}
+	flattened := make(map[string]string, len(multiValueHeaders))
+	for key, values := range multiValueHeaders {
+		flattened[key] = strings.Join(values, ",")
+	}
+	return flattened
+}
+
+func prepareRequestURIALB(request events.ALBTargetGroupRequest) string {
+	headers := albHeaders(request.Headers, request.MultiValueHeaders)
+
+	var uri string
+	if forwardedProtoHeader, found := headers["x-forwarded-proto"]; found {
+		uri = forwardedProtoHeader
+	} else {
+		uri = "http"
+	}
+
+	uri += "://"
+
+	if hostHeader, found := headers["host"]; found {
+		uri += hostHeader
+	} else {
+		uri += "localhost"
+	}
+
+	if request.Path != "" {
+		uri += request.Path
+	} else {
+		uri += "/"
+	}
+
+	// The load balancer doesn't decode the query string parameters, they are kept as received
+	if len(request.MultiValueQueryStringParameters) > 0 {
+		queryString := ""
+		for q, l := range request.MultiValueQueryStringParameters {
+			for _, v := range l {
+				if queryString != "" {
+					queryString += "&"
+				}
+				queryString += q + "=" + v
+			}
+		}
+		uri += "?" + queryString
+	} else if len(request.QueryStringParameters) > 0 {
+		queryString := ""
+		for q := range request.QueryStringParameters {
+			if queryString != "" {
+				queryString += "&"
+			}
+			queryString += q + "=" + request.QueryStringParameters[q]
+		}
+		uri += "?" + queryString
+	}
+	return uri
+}
+
+func prepareEventALB(request events.ALBTargetGroupRequest, response events.ALBTargetGroupResponse, invocation *invocation, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
+	// The events carry no source address, the load balancer appends the address of the client to X-Forwarded-For
+	return newIncomingEvent(incomingRequest{
+		time:     invocation.start,
+		uri:      prepareRequestURIALB(request),
+		verb:     request.HTTPMethod,
+		headers:  albHeaders(request.Headers, request.MultiValueHeaders),
+		ip:       nil,
+		body:     request.Body,
+		isBase64: request.IsBase64Encoded,
+	}, incomingResponse{
+		time:     invocation.end,
+		status:   response.StatusCode,
+		headers:  albHeaders(response.Headers, response.MultiValueHeaders),
+		body:     response.Body,
+		isBase64: response.IsBase64Encoded,
+	}, apiVersion, userId, companyId, sessionToken, metadata)
+}
+
+// The load balancer doesn't authenticate the caller, unless it signs the users in with OIDC and passes their claims in X-Amzn-Oidc-Data.
+// The claims are not verified, they only label the event
+func getUserIdALB(request events.ALBTargetGroupRequest, response events.ALBTargetGroupResponse) *string {
+	return orTokenUserId(getVerifiedUserIdALB(request, response), albHeaders(request.Headers, request.MultiValueHeaders))
+}
+
+// Only the callback identifies the users that are governed and rate limited
+func getVerifiedUserIdALB(request events.ALBTargetGroupRequest, response events.ALBTargetGroupResponse) *string {
+	if identifyUser := moesifConfig.ALBTargetGroup.IdentifyUser; identifyUser != nil {
+		username := identifyUser(request, response)
+		return &username
+	}
+	return nil
+}
+
+func getCompanyIdALB(request events.ALBTargetGroupRequest, response events.ALBTargetGroupResponse) string {
+	return orTokenCompanyId(getVerifiedCompanyIdALB(request, response), albHeaders(request.Headers, request.MultiValueHeaders))
+}
+
+func getVerifiedCompanyIdALB(request events.ALBTargetGroupRequest, response events.ALBTargetGroupResponse) string {
+	if identifyCompany := moesifConfig.ALBTargetGroup.IdentifyCompany; identifyCompany != nil {
+		return identifyCompany(request, response)
+	}
+	return ""
+}
+
+func sendMoesifAsyncALB(request events.ALBTargetGroupRequest, response events.ALBTargetGroupResponse, invocation *invocation) {
+	callbacks := moesifConfig.ALBTargetGroup
+
+	// A failed invocation is logged with the response the client receives
+	if invocation.failure != nil {
+		statusCode, headers, body := invocation.failure.response()
+		response = events.ALBTargetGroupResponse{
+			StatusCode:        statusCode,
+			StatusDescription: fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			Headers:           headers,
+			Body:              body,
+		}
+	}
+
+	// Api Version
+	apiVersion := incomingApiVersion(request.Path, "", albHeaders(request.Headers, request.MultiValueHeaders))
+
+	// Get Metadata
+	var metadata map[string]interface{} = nil
+	if callbacks.GetMetadata != nil {
+		metadata = callbacks.GetMetadata(request, response)
+	}
+
+	// Record the failure and the Lambda context of the invocation
+	metadata = withInvocationMetadata(metadata, invocation, "")
+
+	// Get User
+	var userId *string
+	userId = getUserIdALB(request, response)
+
+	// Get Company
+	var companyId string
+	companyId = getCompanyIdALB(request, response)
+
+	// Get Session Token
+	var sessionToken string
+	if callbacks.GetSessionToken != nil {
+		sessionToken = callbacks.GetSessionToken(request, response)
+	}
+
+	// Sample the event with the rates of the application config
+	weight, sampled := sampleEvent(userId, companyId)
+	if !sampled {
+		return
+	}
+
+	// Prepare Moesif Event
+	moesifEvent := prepareEventALB(request, response, invocation, apiVersion, userId, companyId, sessionToken, metadata)
+	moesifEvent.Weight = &weight
+
+	// Should skip
+	shouldSkip := false
+	if callbacks.ShouldSkip != nil {
+		shouldSkip = callbacks.ShouldSkip(request, response)
+	}
+
+	sendIncomingEvent(moesifEvent, shouldSkip)
+}
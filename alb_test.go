@@ -0,0 +1,67 @@
+package moesifawslambda
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func HandleLambdaEventALB(ctx context.Context, request events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error) {
+	return events.ALBTargetGroupResponse{
+		StatusCode:        200,
+		StatusDescription: "200 OK",
+		MultiValueHeaders: map[string][]string{"Content-Type": {"application/json"}},
+		Body:              request.Body,
+	}, nil
+}
+
+// Generates mock `events.ALBTargetGroupRequest` request objects with multi-value headers enabled.
+func generateALBReq(body []byte) events.ALBTargetGroupRequest {
+	return events.ALBTargetGroupRequest{
+		HTTPMethod:                      "POST",
+		Path:                            "/path/to/foo",
+		MultiValueQueryStringParameters: map[string][]string{"parameter1": {"value%201"}},
+		MultiValueHeaders: map[string][]string{
+			"host":              {"lambda-alb-123578498.us-east-1.elb.amazonaws.com"},
+			"x-forwarded-for":   {"72.12.164.125, 10.0.0.1"},
+			"x-forwarded-proto": {"https"},
+			"accept":            {"text/html", "application/json"},
+		},
+		Body: string(body),
+	}
+}
+
+func TestPrepareRequestURIALB(t *testing.T) {
+	var uri = prepareRequestURIALB(generateALBReq(nil))
+
+	var expected = "https://lambda-alb-123578498.us-east-1.elb.amazonaws.com/path/to/foo?parameter1=value%201"
+
+	if uri != expected {
+		t.Errorf("got %v, want %v", uri, expected)
+	}
+}
+
+func TestMoesifLoggerALB(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEventALB, MoesifOptions()).(func(context.Context, events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error))
+
+	if _, err := handler(context.Background(), generateALBReq([]byte(`{"foo": "bar"}`))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	event := sent[0]
+	if event.Request.IpAddress == nil || *event.Request.IpAddress != "72.12.164.125" {
+		t.Errorf("got ip %v, want 72.12.164.125", event.Request.IpAddress)
+	}
+	if headers := event.Request.Headers.(map[string]string); headers["accept"] != "text/html,application/json" {
+		t.Errorf("got accept header %q, want both values", headers["accept"])
+	}
+	if event.Response.Status != 200 {
+		t.Errorf("got status %d, want 200", event.Response.Status)
+	}
+}
@@ -0,0 +1,90 @@
+package moesifawslambda
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// Where the version of the API is taken from
+const (
+	// The Api_Version option
+	ApiVersionFromStatic = "static"
+	// The stage of the API Gateway API, such as v1
+	ApiVersionFromStage = "stage"
+	// The path of the request, matched against Api_Version_Path_Pattern
+	ApiVersionFromPath = "path"
+	// A header of the request, Api_Version_Header
+	ApiVersionFromHeader = "header"
+)
+
+const (
+	// Matches the first segment of a path that is a version, such as v3 in /v3/users or /prod/v3/users
+	defaultApiVersionPathPattern = `(?:^|/)(v\d+(?:\.\d+)*)(?:/|$)`
+	defaultApiVersionHeader      = "Accept-Version"
+)
+
+// The pattern compiled from Api_Version_Path_Pattern
+var apiVersionPathPattern *regexp.Regexp
+
+// The version of the API matched by the pattern, the first group of the pattern when it has one
+func matchApiVersion(pattern *regexp.Regexp, path string) string {
+	match := pattern.FindStringSubmatch(path)
+	if len(match) > 1 {
+		return match[1]
+	}
+	if len(match) == 1 {
+		return match[0]
+	}
+	return ""
+}
+
+// The version of the API of a request, resolved by Identify_Api_Version or the Api_Version_From strategy.
+// The stage is empty for the requests that have none, such as the outgoing calls
+func apiVersionOf(path string, stage string, headers http.Header) string {
+	if identifyApiVersion := moesifConfig.IdentifyApiVersion; identifyApiVersion != nil {
+		if version := identifyApiVersion(path, stage, headers); version != "" {
+			return version
+		}
+	}
+
+	switch moesifConfig.ApiVersionFrom {
+	case ApiVersionFromStage:
+		if stage != "$default" {
+			return stage
+		}
+	case ApiVersionFromPath:
+		return matchApiVersion(apiVersionPathPattern, path)
+	case ApiVersionFromHeader:
+		return headers.Get(moesifConfig.ApiVersionHeader)
+	}
+	return ""
+}
+
+// The version of the API of an incoming event, Api_Version when it can't be resolved
+func incomingApiVersion(path string, stage string, headers map[string]string) *string {
+	version := apiVersionOf(path, stage, httpHeader(headers))
+	if version == "" {
+		version = moesifConfig.ApiVersion
+	}
+	if version == "" {
+		return nil
+	}
+	return &version
+}
+
+// The version of the API of an outgoing call. Api_Version is the version of the API of the function, not of the called API
+func outgoingApiVersion(request *http.Request) *string {
+	if version := apiVersionOf(request.URL.Path, "", request.Header); version != "" {
+		return &version
+	}
+	return nil
+}
+
+// The headers of an incoming event as an http.Header, so that they can be looked up whatever their case
+func httpHeader(headers map[string]string) http.Header {
+	header := make(http.Header, len(headers))
+	for name, value := range headers {
+		header.Add(name, value)
+	}
+	return header
+}
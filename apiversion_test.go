@@ -0,0 +1,82 @@
+package moesifawslambda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestApiVersionOf(t *testing.T) {
+	headers := http.Header{"Accept-Version": {"2024-01-01"}}
+	tests := []struct {
+		options  []Option
+		path     string
+		stage    string
+		expected string
+	}{
+		{nil, "/v3/users", "v1", ""},
+		{[]Option{WithApiVersionFrom(ApiVersionFromStage)}, "/v3/users", "v1", "v1"},
+		{[]Option{WithApiVersionFrom(ApiVersionFromStage)}, "/v3/users", "$default", ""},
+		{[]Option{WithApiVersionFrom(ApiVersionFromPath)}, "/v3/users", "v1", "v3"},
+		{[]Option{WithApiVersionFrom(ApiVersionFromPath)}, "/prod/v2.1/users", "", "v2.1"},
+		{[]Option{WithApiVersionFrom(ApiVersionFromPath)}, "/users/version", "", ""},
+		{[]Option{WithApiVersionFrom(ApiVersionFromPath), WithApiVersionPathPattern(`^/api/(\d+)/`)}, "/api/7/users", "", "7"},
+		{[]Option{WithApiVersionFrom(ApiVersionFromHeader)}, "/v3/users", "v1", "2024-01-01"},
+		{[]Option{WithApiVersionFrom(ApiVersionFromHeader), WithIdentifyApiVersion(func(path string, stage string, headers http.Header) string {
+			return "custom"
+		})}, "/v3/users", "v1", "custom"},
+	}
+
+	for _, test := range tests {
+		resetClient()
+		config, err := NewConfig(MoesifOptions(), test.options...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		moesifClient(config)
+		if version := apiVersionOf(test.path, test.stage, headers); version != test.expected {
+			t.Errorf("got %q for %q in stage %q with %s, want %q", version, test.path, test.stage, config.ApiVersionFrom, test.expected)
+		}
+	}
+
+	if _, err := NewConfig(nil, WithApiVersionFrom("query")); err == nil {
+		t.Errorf("expected an error for an unknown strategy")
+	}
+}
+
+func TestApiVersionIsResolvedForEveryEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions(), WithApiVersionFrom(ApiVersionFromPath)).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	request := generateProxyReq(nil, false)
+	request.Path = "/v2/users"
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client := &http.Client{Transport: &Transport{Transport: http.DefaultTransport}}
+	response, err := client.Get(server.URL + "/v1/charges")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	response.Body.Close()
+	Flush(context.Background())
+
+	sent := testAPI.events()
+	if len(sent) != 2 {
+		t.Fatalf("got %d events sent, want 2", len(sent))
+	}
+	for i, expected := range []string{"v2", "v1"} {
+		if version := sent[i].Request.ApiVersion; version == nil || *version != expected {
+			t.Errorf("got api version %v for the %s event, want %q", version, *sent[i].Direction, expected)
+		}
+	}
+}
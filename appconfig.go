@@ -0,0 +1,224 @@
+package moesifawslambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	moesifapi "github.com/moesif/moesifapi-go"
+)
+
+// Endpoint of the application config, replaced in tests
+var appConfigURL = moesifapi.BaseURI + "/v1/config"
+
+const (
+	// The application config and the governance rules are refreshed once they are older than this, or when Moesif reports new ones
+	appConfigRefreshInterval = 5 * time.Minute
+	// Fetching them delays the invocation, it is given up quickly when Moesif can't be reached
+	appConfigTimeout = 2 * time.Second
+)
+
+// A random percentage deciding whether an event is sampled, replaced in tests
+var randomPercent = func() int { return rand.Intn(100) }
+
+// appConfig is the configuration of the application in Moesif
+type appConfig struct {
+	SampleRate        *int           `json:"sample_rate"`
+	UserSampleRate    map[string]int `json:"user_sample_rate"`
+	CompanySampleRate map[string]int `json:"company_sample_rate"`
+	// The governance rules of the cohorts of the users and companies
+	UserRules    map[string][]governanceRuleValues `json:"user_rules"`
+	CompanyRules map[string][]governanceRuleValues `json:"company_rules"`
+}
+
+// The percentage of the events of the user and company that are sent, all of them by default
+func (c appConfig) sampleRate(userId *string, companyId string) int {
+	if userId != nil {
+		if rate, found := c.UserSampleRate[*userId]; found {
+			return rate
+		}
+	}
+	if companyId != "" {
+		if rate, found := c.CompanySampleRate[companyId]; found {
+			return rate
+		}
+	}
+	if c.SampleRate != nil {
+		return *c.SampleRate
+	}
+	return 100
+}
+
+// The cache of the application config of the initialized client
+var remoteConfig = newRemoteCache[appConfig](appConfigURL, "")
+
+// remoteCache keeps a document fetched from Moesif, such as the application config, across the warm invocations of the function
+type remoteCache[T any] struct {
+	mutex         sync.Mutex
+	url           string
+	applicationId string
+	value         T
+	etag          string
+	fetchedAt     time.Time
+	stale         bool
+	// The refresh in flight in the background, closed once it completes
+	refreshing chan struct{}
+	refreshes  sync.WaitGroup
+	// The debugging of the client the cache belongs to, read from the background refresh
+	debug bool
+}
+
+func newRemoteCache[T any](url string, applicationId string) *remoteCache[T] {
+	return &remoteCache[T]{url: url, applicationId: applicationId, debug: debug}
+}
+
+// Whether the document should be refreshed. The caller must hold the mutex.
+func (c *remoteCache[T]) due() bool {
+	return c.stale || c.fetchedAt.IsZero() || time.Since(c.fetchedAt) > appConfigRefreshInterval
+}
+
+// The document, refreshed first when it is stale
+func (c *remoteCache[T]) current() T {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.due() {
+		c.update(c.fetch(c.etag))
+	}
+	return c.value
+}
+
+// The document as last fetched, the zero value until then. A stale document is refreshed in the background,
+// so that the invocation is never delayed by Moesif
+func (c *remoteCache[T]) cached() T {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.due() {
+		c.refresh()
+	}
+	return c.value
+}
+
+// The document, waiting for it only until it is first fetched. A stale document is then refreshed in the background like cached
+func (c *remoteCache[T]) loaded() T {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fetchedAt.IsZero() {
+		done := c.refresh()
+		c.mutex.Unlock()
+		<-done
+		c.mutex.Lock()
+	} else if c.due() {
+		c.refresh()
+	}
+	return c.value
+}
+
+// Refresh the document in the background unless a refresh is already in flight. The caller must hold the mutex.
+func (c *remoteCache[T]) refresh() chan struct{} {
+	if c.refreshing == nil {
+		done := make(chan struct{})
+		c.refreshing = done
+		c.refreshes.Add(1)
+		go func(etag string) {
+			defer c.refreshes.Done()
+			value, etag, modified, err := c.fetch(etag)
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.update(value, etag, modified, err)
+			c.refreshing = nil
+			close(done)
+		}(c.etag)
+	}
+	return c.refreshing
+}
+
+// Keep the fetched document. The caller must hold the mutex.
+func (c *remoteCache[T]) update(value T, etag string, modified bool, err error) {
+	if err != nil {
+		if c.debug {
+			log.Printf("Error while fetching %s, using the previous one: %s.\n", c.url, err.Error())
+		}
+	} else if modified {
+		c.value, c.etag = value, etag
+		if c.debug {
+			log.Printf("Fetched %s with etag %s", c.url, c.etag)
+		}
+	}
+	// Failures are not retried before the next refresh, so that an unreachable Moesif doesn't delay every invocation
+	c.fetchedAt = time.Now()
+	c.stale = false
+}
+
+// Fetch the document unless it didn't change since it was fetched with the etag
+func (c *remoteCache[T]) fetch(etag string) (T, string, bool, error) {
+	var value T
+	request, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return value, "", false, err
+	}
+	request.Header.Set("X-Moesif-Application-Id", c.applicationId)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+
+	// The document is fetched with the wrapped transport, so that it is never captured as an outgoing call
+	client := &http.Client{Timeout: appConfigTimeout, Transport: DefaultTransport.transport()}
+	response, err := client.Do(request)
+	if err != nil {
+		return value, "", false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return value, "", false, nil
+	case http.StatusOK:
+		if err := json.NewDecoder(response.Body).Decode(&value); err != nil {
+			return value, "", false, err
+		}
+		return value, configEtag(response.Header), true, nil
+	default:
+		return value, "", false, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+}
+
+// Refresh the document on its next use when Moesif reports one with another etag
+func (c *remoteCache[T]) notify(etag string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if etag != "" && etag != c.etag {
+		c.stale = true
+	}
+}
+
+func configEtag(headers http.Header) string {
+	if etag := headers.Get("X-Moesif-Config-Etag"); etag != "" {
+		return etag
+	}
+	return headers.Get("ETag")
+}
+
+// Sample an event with the rates of the cached application config. Returns the weight of a sampled event,
+// the number of events it stands for, so that Moesif extrapolates the sampled traffic
+func sampleEvent(userId *string, companyId string) (int, bool) {
+	if !moesifConfig.Sampling {
+		return 1, true
+	}
+	// Every event is sampled until the config is first fetched
+	config := remoteConfig.cached()
+	rate := config.sampleRate(userId, companyId)
+	if rate >= 100 {
+		return 1, true
+	}
+	if rate <= 0 || randomPercent() >= rate {
+		if debug {
+			log.Printf("Skip sending the event to Moesif, it is not sampled at %d%%", rate)
+		}
+		return 0, false
+	}
+	return 100 / rate, true
+}
@@ -0,0 +1,182 @@
+package moesifawslambda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// configServer is a stand-in for the config endpoint of Moesif
+type configServer struct {
+	mutex       sync.Mutex
+	config      string
+	etag        string
+	requests    int
+	notModified int
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests++
+	if r.Header.Get("X-Moesif-Application-Id") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("If-None-Match") == s.etag {
+		s.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("X-Moesif-Config-Etag", s.etag)
+	w.Write([]byte(s.config))
+}
+
+func (s *configServer) set(config string, etag string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.config, s.etag = config, etag
+}
+
+func TestEventsAreSampled(t *testing.T) {
+	stub := &configServer{}
+	stub.set(`{"sample_rate": 50, "user_sample_rate": {"vip": 100, "bot": 0}, "company_sample_rate": {"acme": 25}}`, "etag-1")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	defer func(url string) { appConfigURL = url }(appConfigURL)
+	appConfigURL = server.URL
+	defer func(random func() int) { randomPercent = random }(randomPercent)
+	randomPercent = func() int { return 40 }
+
+	t.Setenv("MOESIF_APPLICATION_ID", "app-id")
+	resetClient()
+	var userId, companyId string
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions(), WithAPIGatewayProxyCallbacks(APIGatewayProxyCallbacks{
+		IdentifyUser:    func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string { return userId },
+		IdentifyCompany: func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string { return companyId },
+	})).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	// The first event doesn't wait for the config, it is sent while the config is fetched in the background
+	if _, err := handler(context.Background(), generateProxyReq(nil, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sent := testAPI.events(); len(sent) != 1 || *sent[0].Weight != 1 {
+		t.Errorf("got %d events sent before the config is fetched, want 1 of weight 1", len(sent))
+	}
+	remoteConfig.refreshes.Wait()
+
+	// The weight of each sampled event, 0 when it is not sampled
+	tests := []struct {
+		userId    string
+		companyId string
+		weight    int
+	}{
+		{"", "", 2},
+		{"vip", "acme", 1},
+		{"bot", "", 0},
+		{"", "acme", 0},
+	}
+	for _, test := range tests {
+		testAPI.mutex.Lock()
+		testAPI.batches = nil
+		testAPI.mutex.Unlock()
+
+		userId, companyId = test.userId, test.companyId
+		if _, err := handler(context.Background(), generateProxyReq(nil, false)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sent := testAPI.events()
+		if test.weight == 0 {
+			if len(sent) != 0 {
+				t.Errorf("got %d events sent for user %q and company %q, want none", len(sent), test.userId, test.companyId)
+			}
+			continue
+		}
+		if len(sent) != 1 || *sent[0].Weight != test.weight {
+			t.Errorf("got %d events sent for user %q and company %q, want 1 of weight %d", len(sent), test.userId, test.companyId, test.weight)
+		}
+	}
+
+	// The config is cached across invocations
+	if stub.requests != 1 {
+		t.Errorf("got %d config requests, want 1", stub.requests)
+	}
+
+	// A batch reporting the same etag leaves the config cached, another etag refreshes it on the next event
+	remoteConfig.notify("etag-1")
+	remoteConfig.current()
+	stub.set(`{"sample_rate": 100}`, "etag-2")
+	remoteConfig.notify("etag-2")
+	if rate := remoteConfig.current().sampleRate(nil, ""); rate != 100 {
+		t.Errorf("got sample rate %d, want the refreshed rate", rate)
+	}
+	if stub.requests != 2 {
+		t.Errorf("got %d config requests, want 2", stub.requests)
+	}
+}
+
+func TestConfigIsRefreshedWithItsEtag(t *testing.T) {
+	stub := &configServer{}
+	stub.set(`{"sample_rate": 10}`, "etag-1")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	defer func(url string) { appConfigURL = url }(appConfigURL)
+	appConfigURL = server.URL
+
+	cache := newRemoteCache[appConfig](appConfigURL, "app-id")
+	cache.current()
+	// The config is refreshed, but it didn't change
+	cache.stale = true
+	if rate := cache.current().sampleRate(nil, ""); rate != 10 {
+		t.Errorf("got sample rate %d, want the cached rate", rate)
+	}
+	if stub.requests != 2 || stub.notModified != 1 {
+		t.Errorf("got %d config requests, %d not modified, want 2 and 1", stub.requests, stub.notModified)
+	}
+
+	// The previous config is kept when Moesif can't be reached
+	server.Close()
+	cache.stale = true
+	if rate := cache.current().sampleRate(nil, ""); rate != 10 {
+		t.Errorf("got sample rate %d, want the previous rate", rate)
+	}
+}
+
+func TestLoadedWaitsOnlyForTheFirstFetch(t *testing.T) {
+	stub := &configServer{}
+	stub.set(`{"sample_rate": 10}`, "etag-1")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	// Concurrent invocations share the first fetch
+	cache := newRemoteCache[appConfig](server.URL, "app-id")
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rate := cache.loaded().sampleRate(nil, ""); rate != 10 {
+				t.Errorf("got sample rate %d, want the fetched rate", rate)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A stale config is used while it is refreshed in the background
+	stub.set(`{"sample_rate": 100}`, "etag-2")
+	cache.notify("etag-2")
+	if rate := cache.loaded().sampleRate(nil, ""); rate != 10 {
+		t.Errorf("got sample rate %d, want the stale rate", rate)
+	}
+	cache.refreshes.Wait()
+	if rate := cache.loaded().sampleRate(nil, ""); rate != 100 {
+		t.Errorf("got sample rate %d, want the refreshed rate", rate)
+	}
+	if stub.requests != 2 {
+		t.Errorf("got %d config requests, want 2", stub.requests)
+	}
+}
@@ -0,0 +1,117 @@
+package moesifawslambda
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kinds of bodies, told apart by their Content-Type
+const (
+	bodyUnknown = iota
+	bodyJSON
+	bodyForm
+	bodyText
+	bodyBinary
+)
+
+// Replace binary bodies by a summary of their type and size
+var summarizeBinaryBodies bool
+
+// The media type of the Content-Type header, in lower case and without its parameters
+func mediaType(contentType string) string {
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		return parsed
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func bodyKind(contentType string) int {
+	mediaType := mediaType(contentType)
+	topLevel, subType, _ := strings.Cut(mediaType, "/")
+	switch {
+	case mediaType == "":
+		return bodyUnknown
+	case subType == "json" || strings.HasSuffix(subType, "+json"):
+		return bodyJSON
+	case mediaType == "application/x-www-form-urlencoded":
+		return bodyForm
+	case topLevel == "text", subType == "xml", strings.HasSuffix(subType, "+xml"),
+		mediaType == "application/graphql", mediaType == "application/javascript",
+		mediaType == "application/yaml", mediaType == "application/x-yaml":
+		return bodyText
+	case topLevel == "image", topLevel == "audio", topLevel == "video", topLevel == "font",
+		mediaType == "application/octet-stream", mediaType == "application/pdf", mediaType == "application/zip",
+		mediaType == "application/gzip", mediaType == "application/protobuf", mediaType == "application/x-protobuf":
+		return bodyBinary
+	default:
+		return bodyUnknown
+	}
+}
+
+// Form fields with a single value are kept as a string, the others as a list
+func formBody(values url.Values) map[string]interface{} {
+	form := make(map[string]interface{}, len(values))
+	for name, fieldValues := range values {
+		if len(fieldValues) == 1 {
+			form[name] = fieldValues[0]
+			continue
+		}
+		list := make([]interface{}, len(fieldValues))
+		for i, value := range fieldValues {
+			list[i] = value
+		}
+		form[name] = list
+	}
+	return form
+}
+
+// Parse a body according to its Content-Type, returns the body and its transfer encoding.
+// Bodies of an unknown type are parsed as JSON or base64 encoded by processBody
+func parseBody(body []byte, contentType string) (interface{}, string) {
+	switch bodyKind(contentType) {
+	case bodyJSON:
+		var parsedBody interface{}
+		if err := json.Unmarshal(body, &parsedBody); err == nil {
+			return parsedBody, "json"
+		}
+		// Malformed JSON is logged as text
+		return textBody(body)
+	case bodyForm:
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return formBody(values), "json"
+		}
+		return textBody(body)
+	case bodyText:
+		return textBody(body)
+	case bodyBinary:
+		if summarizeBinaryBodies {
+			return map[string]interface{}{"content_type": mediaType(contentType), "size": len(body)}, "json"
+		}
+		return b64.StdEncoding.EncodeToString(body), "base64"
+	default:
+		return processBody(string(body))
+	}
+}
+
+// Keep a text body as a string, unless it is not valid UTF-8
+func textBody(body []byte) (interface{}, string) {
+	if utf8.Valid(body) {
+		return string(body), "json"
+	}
+	return b64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// A header of an incoming event, whatever the case of the header name
+func headerValue(headers map[string]string, name string) string {
+	for header, value := range headers {
+		if strings.EqualFold(header, name) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package moesifawslambda
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestParseBody(t *testing.T) {
+	tests := []struct {
+		body        string
+		contentType string
+		expected    interface{}
+		encoding    string
+	}{
+		{`{"foo": "bar"}`, "application/json; charset=utf-8", map[string]interface{}{"foo": "bar"}, "json"},
+		{`{"foo": "bar"}`, "application/vnd.api+json", map[string]interface{}{"foo": "bar"}, "json"},
+		{`{"foo": `, "application/json", `{"foo": `, "json"},
+		{"a=1&b=2&b=3&c=x%20y", "application/x-www-form-urlencoded", map[string]interface{}{"a": "1", "b": []interface{}{"2", "3"}, "c": "x y"}, "json"},
+		{"<note>hello</note>", "application/xml", "<note>hello</note>", "json"},
+		{"query { user { id } }", "application/graphql", "query { user { id } }", "json"},
+		{"hello", "Text/Plain", "hello", "json"},
+		{"\x89PNG", "image/png", b64.StdEncoding.EncodeToString([]byte("\x89PNG")), "base64"},
+		// Bodies without a Content-Type are parsed as before
+		{`{"foo": "bar"}`, "", map[string]interface{}{"foo": "bar"}, "json"},
+		{"hello", "", b64.StdEncoding.EncodeToString([]byte("hello")), "base64"},
+	}
+
+	for _, test := range tests {
+		body, encoding := parseBody([]byte(test.body), test.contentType)
+		if !reflect.DeepEqual(body, test.expected) || encoding != test.encoding {
+			t.Errorf("got %v (%s) for %q as %q, want %v (%s)", body, encoding, test.body, test.contentType, test.expected, test.encoding)
+		}
+	}
+}
+
+func TestMoesifLoggerSummarizesBinaryBodies(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{
+			StatusCode:      200,
+			Headers:         map[string]string{"content-type": "image/png"},
+			Body:            b64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n")),
+			IsBase64Encoded: true,
+		}, nil
+	}, MoesifOptions(), WithSummarizeBinaryBodies(true)).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	// A form post, base64 encoded by API Gateway
+	request := generateProxyReq([]byte(b64.StdEncoding.EncodeToString([]byte("name=jane"))), true)
+	request.Headers = map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if body := *sent[0].Request.Body; !reflect.DeepEqual(body, map[string]interface{}{"name": "jane"}) {
+		t.Errorf("got request body %v, want the form fields", body)
+	}
+	expected := map[string]interface{}{"content_type": "image/png", "size": 8}
+	if body := *sent[0].Response.Body.(*interface{}); !reflect.DeepEqual(body, expected) {
+		t.Errorf("got response body %v, want %v", body, expected)
+	}
+}
@@ -0,0 +1,213 @@
+package moesifawslambda
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// Bodies larger than this are truncated, in bytes
+const defaultMaxBodySize = 256 * 1024
+
+// Key of the metadata recording the original size of the truncated bodies
+const truncatedBodyMetadataKey = "truncated_body"
+
+// Key of the metadata recording the size read of the response bodies that were closed or flushed before their end
+const partialBodyMetadataKey = "partial_body"
+
+// Cut a body to the limit, keeping a text body valid UTF-8. A limit of 0 keeps the whole body
+func truncateBody(body []byte, limit int) ([]byte, bool) {
+	if limit <= 0 || len(body) <= limit {
+		return body, false
+	}
+	return trimPartialRune(body[:limit]), true
+}
+
+// Drop the bytes of a rune split by the truncation, so that a truncated text body stays valid UTF-8
+func trimPartialRune(body []byte) []byte {
+	for i := len(body) - 1; i >= 0 && i >= len(body)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(body[i]) {
+			if !utf8.FullRune(body[i:]) {
+				return body[:i]
+			}
+			break
+		}
+	}
+	return body
+}
+
+// bodyTruncation is the original size of a truncated body, -1 when it was not truncated. The size is only a lower bound
+// when the decompression of the body was capped by Max_Decompressed_Body_Size
+type bodyTruncation struct {
+	size   int64
+	capped bool
+}
+
+var notTruncated = bodyTruncation{size: -1}
+
+// Record the original size of the truncated request and response bodies, and the sides whose decompression was capped
+func withTruncatedBodies(metadata map[string]interface{}, request, response bodyTruncation) map[string]interface{} {
+	sizes := map[string]interface{}{}
+	capped := map[string]interface{}{}
+	for side, truncation := range map[string]bodyTruncation{"request": request, "response": response} {
+		if truncation.size >= 0 {
+			sizes[side] = truncation.size
+		}
+		if truncation.capped {
+			capped[side] = true
+		}
+	}
+	if len(sizes) == 0 {
+		return metadata
+	}
+	if len(capped) > 0 {
+		sizes["decompression_capped"] = capped
+	}
+	return withMetadata(metadata, truncatedBodyMetadataKey, sizes)
+}
+
+// Record the size read of a response body that was not read to the end, -1 when it was
+func withPartialBody(metadata map[string]interface{}, responseRead int64) map[string]interface{} {
+	if responseRead < 0 {
+		return metadata
+	}
+	return withMetadata(metadata, partialBodyMetadataKey, map[string]interface{}{"response": responseRead})
+}
+
+// bodyCapture keeps the first bytes written to it, up to the limit, and counts all of them
+type bodyCapture struct {
+	buffer bytes.Buffer
+	limit  int
+	size   int64
+	// Whether the body was decompressed up to Max_Decompressed_Body_Size only, its size is then a lower bound
+	capped bool
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	kept := p
+	if c.limit > 0 {
+		remaining := c.limit - c.buffer.Len()
+		if remaining < 0 {
+			remaining = 0
+		}
+		if len(kept) > remaining {
+			kept = kept[:remaining]
+		}
+	}
+	c.buffer.Write(kept)
+	return len(p), nil
+}
+
+// The captured body and whether it was truncated
+func (c *bodyCapture) body() ([]byte, bool) {
+	if c.limit <= 0 || c.size <= int64(c.limit) {
+		return c.buffer.Bytes(), false
+	}
+	return trimPartialRune(c.buffer.Bytes()), true
+}
+
+// The original size of the body when it was truncated or its decompression was capped
+func (c *bodyCapture) truncation() bodyTruncation {
+	if _, truncated := c.body(); truncated || c.capped {
+		return bodyTruncation{size: c.size, capped: c.capped}
+	}
+	return notTruncated
+}
+
+// teeBody captures a response body while the application reads it, so that it is never buffered
+// beyond the limit. done is called once, in the background, when the body is read to the end, closed,
+// or flushed at the end of the invocation; complete is false unless it was read to the end
+type teeBody struct {
+	io.ReadCloser
+	mutex    sync.Mutex
+	capture  *bodyCapture
+	finished bool
+	done     func(capture *bodyCapture, complete bool)
+}
+
+// The tee bodies that are not finished yet, and the done callbacks that are running
+var (
+	pendingTeeBodiesMutex sync.Mutex
+	pendingTeeBodies      = map[*teeBody]struct{}{}
+	runningTeeBodies      sync.WaitGroup
+)
+
+func newTeeBody(body io.ReadCloser, limit int, done func(capture *bodyCapture, complete bool)) *teeBody {
+	t := &teeBody{ReadCloser: body, capture: &bodyCapture{limit: limit}, done: done}
+	pendingTeeBodiesMutex.Lock()
+	pendingTeeBodies[t] = struct{}{}
+	pendingTeeBodiesMutex.Unlock()
+	return t
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.mutex.Lock()
+		// The bytes read after the body is flushed are not captured
+		if !t.finished {
+			t.capture.Write(p[:n])
+		}
+		t.mutex.Unlock()
+	}
+	if err == io.EOF {
+		t.finish(true)
+	}
+	return n, err
+}
+
+func (t *teeBody) Close() error {
+	err := t.ReadCloser.Close()
+	t.finish(false)
+	return err
+}
+
+// Stop capturing and call done off the Read or Close of the application
+func (t *teeBody) finish(complete bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.finished {
+		return
+	}
+	t.finished = true
+
+	pendingTeeBodiesMutex.Lock()
+	delete(pendingTeeBodies, t)
+	pendingTeeBodiesMutex.Unlock()
+
+	runningTeeBodies.Add(1)
+	go func() {
+		defer runningTeeBodies.Done()
+		t.done(t.capture, complete)
+	}()
+}
+
+// Finish the bodies the application didn't read to the end or close, so that their events are sent with what was read
+func finishTeeBodies() {
+	pendingTeeBodiesMutex.Lock()
+	pending := make([]*teeBody, 0, len(pendingTeeBodies))
+	for t := range pendingTeeBodies {
+		pending = append(pending, t)
+	}
+	pendingTeeBodiesMutex.Unlock()
+
+	for _, t := range pending {
+		t.finish(false)
+	}
+}
+
+// Wait until the done callbacks of the finished bodies have queued their events, or ctx is done
+func waitTeeBodies(ctx context.Context) {
+	waited := make(chan struct{})
+	go func() {
+		runningTeeBodies.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,192 @@
+package moesifawslambda
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestTruncateBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		limit    int
+		expected string
+	}{
+		{"hello", 0, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 4, "hell"},
+		// The second byte of é is cut, the whole rune is dropped
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"日本", 5, "日"},
+	}
+
+	for _, test := range tests {
+		body, truncated := truncateBody([]byte(test.body), test.limit)
+		if string(body) != test.expected || truncated != (test.expected != test.body) {
+			t.Errorf("got %q, %v for %q cut to %d, want %q", body, truncated, test.body, test.limit, test.expected)
+		}
+	}
+}
+
+func TestMoesifLoggerTruncatesBodies(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions(), WithMaxBodySize(4)).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	request := generateProxyReq([]byte("héllo world"), false)
+	request.Headers = map[string]string{"Content-Type": "text/plain"}
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if body := *sent[0].Request.Body; body != "hél" {
+		t.Errorf("got request body %q, want it truncated", body)
+	}
+	// The handler echoes the request body as malformed JSON, which is logged as text
+	if body := *sent[0].Response.Body.(*interface{}); body != "hél" {
+		t.Errorf("got response body %q, want it truncated", body)
+	}
+	expected := map[string]interface{}{"request": int64(12), "response": int64(12)}
+	if sizes := (*sent[0].Metadata.(*map[string]interface{}))[truncatedBodyMetadataKey]; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("got truncated body sizes %v, want %v", sizes, expected)
+	}
+}
+
+func TestOutgoingResponseBodiesAreTruncated(t *testing.T) {
+	payload := strings.Repeat("a", 64*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(payload))
+	}))
+	defer server.Close()
+
+	resetClient()
+	config, err := NewConfig(MoesifOptions(), WithMaxBodySizeOutgoing(16))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moesifClient(config)
+	logBodyOutgoing = true
+	defer func() { logBodyOutgoing = false }()
+
+	client := &http.Client{Transport: &Transport{Transport: http.DefaultTransport}}
+	response, err := client.Post(server.URL, "text/plain", strings.NewReader("request body longer than the limit"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The application reads the whole body
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil || string(body) != payload {
+		t.Fatalf("got %d bytes, %v read by the application, want the whole body", len(body), err)
+	}
+	Flush(context.Background())
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if body := *sent[0].Request.Body; body != "request body lon" {
+		t.Errorf("got request body %q, want it truncated", body)
+	}
+	if body := sent[0].Response.Body; body != strings.Repeat("a", 16) {
+		t.Errorf("got response body %q, want it truncated", body)
+	}
+	expected := map[string]interface{}{"request": int64(34), "response": int64(len(payload))}
+	if sizes := sent[0].Metadata.(map[string]interface{})[truncatedBodyMetadataKey]; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("got truncated body sizes %v, want %v", sizes, expected)
+	}
+}
+
+func TestUnfinishedOutgoingResponseBodiesAreFlushed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("response body"))
+	}))
+	defer server.Close()
+
+	resetClient()
+	logBodyOutgoing = true
+	defer func() { logBodyOutgoing = false }()
+	client := &http.Client{Transport: &Transport{Transport: http.DefaultTransport}}
+	var responses []*http.Response
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		// The application reads a part of a body, and never reads nor closes another one
+		for _, read := range []int{4, 0} {
+			response, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			io.ReadFull(response.Body, make([]byte, read))
+			responses = append(responses, response)
+		}
+		return HandleLambdaEvent(ctx, request)
+	}, MoesifOptions()).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+	if _, err := handler(context.Background(), generateProxyReq(nil, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		for _, response := range responses {
+			response.Body.Close()
+		}
+	}()
+
+	read := map[interface{}]bool{}
+	for _, event := range testAPI.events() {
+		if metadata, ok := event.Metadata.(map[string]interface{}); ok {
+			partial, _ := metadata[partialBodyMetadataKey].(map[string]interface{})
+			read[partial["response"]] = true
+		}
+	}
+	if len(read) != 2 || !read[int64(4)] || !read[int64(0)] {
+		t.Errorf("got the partial bodies %v of the outgoing events, want the sizes read", read)
+	}
+}
+
+func TestFlushSendsUnfinishedOutgoingResponseBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("response body"))
+	}))
+	defer server.Close()
+
+	resetClient()
+	config, err := NewConfig(MoesifOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moesifClient(config)
+	logBodyOutgoing = true
+	defer func() { logBodyOutgoing = false }()
+
+	// Outside of a wrapped handler, the application reads a part of the body
+	client := &http.Client{Transport: &Transport{Transport: http.DefaultTransport}}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer response.Body.Close()
+	io.ReadFull(response.Body, make([]byte, 4))
+	Flush(context.Background())
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	partial, _ := sent[0].Metadata.(map[string]interface{})[partialBodyMetadataKey].(map[string]interface{})
+	if partial["response"] != int64(4) {
+		t.Errorf("got the partial bodies %v, want the 4 bytes read", partial)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 	"strings"
+	"io"
 	"io/ioutil"
 	"bytes"
 )
@@ -46,8 +47,9 @@ func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
 
 	// Skip capture outgoing event
 	shouldSkipOutgoing := false
-	if _, found := moesifOption["Should_Skip_Outgoing"]; found {
-		shouldSkipOutgoing = moesifOption["Should_Skip_Outgoing"].(func(*http.Request, *http.Response) bool)(request, response)
+	callbacks := moesifConfig.Outgoing
+	if callbacks.ShouldSkip != nil {
+		shouldSkipOutgoing = callbacks.ShouldSkip(request, response)
 	}
 
 	// Skip / Send event to moesif
@@ -67,83 +69,110 @@ func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
 			// Get Request Body
 			var outgoingReqBody interface{}
 			var reqEncoding string
+			reqBodySize := notTruncated
 			outgoingReqBody = nil
-			if logBodyOutgoing && request.Body != nil {
+			if logBodyOutgoing && request.Body != nil && request.GetBody != nil {
 				copyBody, err := request.GetBody()
 				if err != nil {
 					if debug{
 						log.Printf("Error while getting the outgoing request body: %s.\n", err.Error())
 					}
-				}
-			
-				// Read the request body
-				readReqBody, reqBodyErr := ioutil.ReadAll(copyBody)
-				if reqBodyErr != nil {	
-					if debug {
-						log.Printf("Error while reading outgoing request body: %s.\n", reqBodyErr.Error())
+				} else {
+					// Read the request body, keeping at most Max_Body_Size_Outgoing bytes of it
+					capture := &bodyCapture{limit: moesifConfig.MaxBodySizeOutgoing}
+					if _, reqBodyErr := io.Copy(capture, copyBody); reqBodyErr != nil {
+						if debug {
+							log.Printf("Error while reading outgoing request body: %s.\n", reqBodyErr.Error())
+						}
 					}
-				}
-			
-				// Parse the request Body
-				outgoingReqBody, reqEncoding = processBody(string(readReqBody))
-			
-				// Return io.ReadCloser while making sure a Close() is available for request body
-				request.Body = ioutil.NopCloser(bytes.NewBuffer(readReqBody))
-			
-			}
-  
-			// Get Response Body
-			var outgoingRespBody interface{}
-			var respEncoding string
-			outgoingRespBody = nil
-			if logBodyOutgoing && response.Body != nil {
-				// Read the response body
-				readRespBody, err := ioutil.ReadAll(response.Body)
-				if err != nil {
-					if debug {
-						log.Printf("Error while reading outgoing response body: %s.\n", err.Error())
+					copyBody.Close()
+
+					var readReqBody []byte
+					readReqBody, reqBodySize = capturedBody(capture, request.Header.Get("Content-Encoding"))
+
+					// Parse the request Body
+					outgoingReqBody, reqEncoding = parseBody(readReqBody, request.Header.Get("Content-Type"))
+
+					// Return io.ReadCloser while making sure a Close() is available for request body
+					if body, err := request.GetBody(); err == nil {
+						request.Body = body
 					}
 				}
+			}
 
+			// Send the event once the response body is captured
+			sendEvent := func(readRespBody []byte, respBodySize bodyTruncation, respBodyRead int64) {
 				// Parse the response Body
-				outgoingRespBody, respEncoding = processBody(string(readRespBody))
-	
-				// Return io.ReadCloser while making sure a Close() is available for response body
-				response.Body = ioutil.NopCloser(bytes.NewBuffer(readRespBody))
-			}
-			
-		
-			// Get Outgoing Event Metadata
-			var metadataOutgoing map[string]interface{} = nil
-			if _, found := moesifOption["Get_Metadata_Outgoing"]; found {
-				metadataOutgoing = moesifOption["Get_Metadata_Outgoing"].(func(*http.Request, *http.Response) map[string]interface{})(request, response)
-			}
-		
-			// Get Outgoing User
-			var userIdOutgoing string
-			if _, found := moesifOption["Identify_User_Outgoing"]; found {
-				userIdOutgoing = moesifOption["Identify_User_Outgoing"].(func(*http.Request, *http.Response) string)(request, response)
-			}
+				var outgoingRespBody interface{}
+				var respEncoding string
+				callbackResponse := response
+				if readRespBody != nil {
+					outgoingRespBody, respEncoding = parseBody(readRespBody, response.Header.Get("Content-Type"))
+
+					// The callbacks read the captured response body
+					captured := *response
+					captured.Body = ioutil.NopCloser(bytes.NewReader(readRespBody))
+					callbackResponse = &captured
+				}
 
-			// Get Outgoing Company
-			var companyIdOutgoing string
-			if _, found := moesifOption["Identify_Company_Outgoing"]; found {
-				companyIdOutgoing = moesifOption["Identify_Company_Outgoing"].(func(*http.Request, *http.Response) string)(request, response)
-			}
-		
-			// Get Outgoing Session Token
-			var sessionTokenOutgoing string
-			if _, found := moesifOption["Get_Session_Token_Outgoing"]; found {
-				sessionTokenOutgoing = moesifOption["Get_Session_Token_Outgoing"].(func(*http.Request, *http.Response) string)(request, response)
-			}
+				// Get Outgoing Event Metadata
+				var metadataOutgoing map[string]interface{} = nil
+				if callbacks.GetMetadata != nil {
+					metadataOutgoing = callbacks.GetMetadata(request, callbackResponse)
+				}
 
-			direction := "Outgoing"
-	 		weight := 1
+				// Record the original size of the truncated bodies
+				metadataOutgoing = withTruncatedBodies(metadataOutgoing, reqBodySize, respBodySize)
+				// And the size read of a response body that was not read to the end
+				metadataOutgoing = withPartialBody(metadataOutgoing, respBodyRead)
 
-			// Send Event To Moesif
-			sendMoesifOutgoingAsync(request, outgoingReqTime, nil, outgoingReqBody, &reqEncoding, outgoingRspTime, response.StatusCode, 
-							response.Header, outgoingRespBody, &respEncoding, &userIdOutgoing, &companyIdOutgoing, &sessionTokenOutgoing, metadataOutgoing,
-							&direction, &weight)		
+				// Get Outgoing User
+				var userIdOutgoing string
+				if callbacks.IdentifyUser != nil {
+					userIdOutgoing = callbacks.IdentifyUser(request, callbackResponse)
+				}
+
+				// Get Outgoing Company
+				var companyIdOutgoing string
+				if callbacks.IdentifyCompany != nil {
+					companyIdOutgoing = callbacks.IdentifyCompany(request, callbackResponse)
+				}
+
+				// Get Outgoing Session Token
+				var sessionTokenOutgoing string
+				if callbacks.GetSessionToken != nil {
+					sessionTokenOutgoing = callbacks.GetSessionToken(request, callbackResponse)
+				}
+
+				// Sample the event with the rates of the application config
+				weight, sampled := sampleEvent(&userIdOutgoing, companyIdOutgoing)
+				if !sampled {
+					return
+				}
+
+				direction := "Outgoing"
+
+				// Send Event To Moesif
+				sendMoesifOutgoingAsync(request, outgoingReqTime, outgoingApiVersion(request), outgoingReqBody, &reqEncoding, outgoingRspTime, response.StatusCode,
+					response.Header, outgoingRespBody, &respEncoding, &userIdOutgoing, &companyIdOutgoing, &sessionTokenOutgoing, metadataOutgoing,
+					&direction, &weight)
+			}
+
+			// Get Response Body
+			if logBodyOutgoing && response.Body != nil && response.Body != http.NoBody {
+				// Capture the response body while the application reads it, keeping at most Max_Body_Size_Outgoing bytes of it.
+				// The event is sent when the body is read to the end or closed, or at the end of the invocation
+				response.Body = newTeeBody(response.Body, moesifConfig.MaxBodySizeOutgoing, func(capture *bodyCapture, complete bool) {
+					var respBodyRead int64 = -1
+					if !complete {
+						respBodyRead = capture.size
+					}
+					readRespBody, respBodySize := capturedBody(capture, response.Header.Get("Content-Encoding"))
+					sendEvent(readRespBody, respBodySize, respBodyRead)
+				})
+			} else {
+				sendEvent(nil, notTruncated, -1)
+			}
 			} else {
 				if debug {
 					log.Println("Request Skipped since it is Moesif Event")
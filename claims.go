@@ -0,0 +1,170 @@
+package moesifawslambda
+
+import (
+	"bytes"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The claims identifying the user by default: the subject of a JWT, or the principal of a Lambda authorizer
+var defaultUserIdClaims = []string{"sub", "principalId"}
+
+// The claims identifying the company by default, as set by identity providers and Lambda authorizers
+var defaultCompanyIdClaims = []string{
+	"company_id",
+	"companyId",
+	"org_id",
+	"orgId",
+	"organization_id",
+	"tenant_id",
+	"tenantId",
+	"custom:company_id",
+	"custom:org_id",
+	"custom:tenant_id",
+}
+
+// Headers carrying a JWT: the bearer token of the client, or the claims of the user signed in by an ALB
+var tokenHeaders = []string{"Authorization", "X-Amzn-Oidc-Data"}
+
+// claims are the claims of a token or the context of an authorizer
+type claims map[string]interface{}
+
+// The value of the first of the claims that is set, as a string
+func (c claims) first(names []string) string {
+	for _, name := range names {
+		switch value := c[name].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case json.Number:
+			return value.String()
+		case float64:
+			return fmt.Sprintf("%.0f", value)
+		case bool:
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// The claims of the JWT in the headers of the request. The token is decoded without its signature being verified,
+// so anyone can forge them: they only label the logged events, and never identify the caller for governance or rate limiting
+func tokenClaims(headers map[string]string) claims {
+	for _, header := range tokenHeaders {
+		token := strings.TrimSpace(headerValue(headers, header))
+		if scheme, credentials, found := strings.Cut(token, " "); found {
+			if !strings.EqualFold(scheme, "Bearer") {
+				continue
+			}
+			token = strings.TrimSpace(credentials)
+		}
+
+		segments := strings.Split(token, ".")
+		if len(segments) != 3 {
+			continue
+		}
+		payload, err := b64.RawURLEncoding.DecodeString(strings.TrimRight(segments[1], "="))
+		if err != nil {
+			continue
+		}
+		var decoded claims
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded); err == nil {
+			return decoded
+		}
+	}
+	return nil
+}
+
+// The value of the first of the claims verified by the authorizer
+func claimValue(names []string, authorizer []claims) string {
+	if len(names) == 0 {
+		return ""
+	}
+	for _, source := range authorizer {
+		if value := source.first(names); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// The claims of the authorizer of a REST API or WebSocket API: the claims of a Cognito user pool authorizer, or the context of a Lambda authorizer
+func authorizerClaims(authorizer interface{}) []claims {
+	context, ok := authorizer.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if userPoolClaims, ok := context["claims"].(map[string]interface{}); ok {
+		return []claims{userPoolClaims, context}
+	}
+	return []claims{context}
+}
+
+// The claims of the authorizer of an HTTP API: the claims of a JWT authorizer, or the context of a Lambda authorizer
+func authorizerClaimsV2HTTP(authorizer *events.APIGatewayV2HTTPRequestContextAuthorizerDescription) []claims {
+	if authorizer == nil {
+		return nil
+	}
+	var sources []claims
+	if authorizer.JWT != nil {
+		jwtClaims := make(claims, len(authorizer.JWT.Claims))
+		for name, value := range authorizer.JWT.Claims {
+			jwtClaims[name] = value
+		}
+		sources = append(sources, jwtClaims)
+	}
+	if authorizer.Lambda != nil {
+		sources = append(sources, authorizer.Lambda)
+	}
+	return sources
+}
+
+// The user identified by the claims of the authorizer, nil when none of the claims is set
+func claimsUserId(authorizer []claims) *string {
+	if userId := claimValue(moesifConfig.UserIdClaims, authorizer); userId != "" {
+		return &userId
+	}
+	return nil
+}
+
+// The company identified by the claims of the authorizer, empty when none of the claims is set
+func claimsCompanyId(authorizer []claims) string {
+	return claimValue(moesifConfig.CompanyIdClaims, authorizer)
+}
+
+// The company identified by the claims of the authorizer, or else by the API key of the usage plan of a REST API or WebSocket API
+func claimsOrApiKeyCompanyId(authorizer []claims, identity events.APIGatewayRequestIdentity) string {
+	if companyId := claimsCompanyId(authorizer); companyId != "" {
+		return companyId
+	}
+	if moesifConfig.CompanyFromApiKey {
+		return identity.APIKeyID
+	}
+	return ""
+}
+
+// The verified user, or else the user of the unverified JWT of the request to label its event
+func orTokenUserId(userId *string, headers map[string]string) *string {
+	if userId != nil || len(moesifConfig.UserIdClaims) == 0 {
+		return userId
+	}
+	if tokenUserId := tokenClaims(headers).first(moesifConfig.UserIdClaims); tokenUserId != "" {
+		return &tokenUserId
+	}
+	return nil
+}
+
+// The verified company, or else the company of the unverified JWT of the request to label its event
+func orTokenCompanyId(companyId string, headers map[string]string) string {
+	if companyId != "" || len(moesifConfig.CompanyIdClaims) == 0 {
+		return companyId
+	}
+	return tokenClaims(headers).first(moesifConfig.CompanyIdClaims)
+}
@@ -0,0 +1,139 @@
+package moesifawslambda
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// An unsigned JWT with the given claims, the signature is never verified
+func testToken(payload string) string {
+	return b64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+}
+
+func TestUsersAreIdentifiedByTheirClaims(t *testing.T) {
+	resetClient()
+	config, err := NewConfig(MoesifOptions(), WithCompanyIdClaims("org_id", "custom:tenant"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moesifClient(config)
+
+	token := testToken(`{"sub": "token-user", "org_id": 42}`)
+	tests := []struct {
+		name      string
+		userId    *string
+		companyId string
+		expected  string
+		company   string
+	}{
+		{
+			"Cognito user pool authorizer",
+			getUserId(events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{"sub": "pool-user", "custom:tenant": "acme"},
+			}}}, events.APIGatewayProxyResponse{}),
+			getCompanyId(events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{"sub": "pool-user", "custom:tenant": "acme"},
+			}}}, events.APIGatewayProxyResponse{}),
+			"pool-user", "acme",
+		},
+		{
+			"Lambda authorizer",
+			getUserId(events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{
+				"principalId": "principal",
+			}}}, events.APIGatewayProxyResponse{}),
+			"", "principal", "",
+		},
+		{
+			"Cognito identity first",
+			getUserId(events.APIGatewayProxyRequest{
+				Headers:        map[string]string{"Authorization": "Bearer " + token},
+				RequestContext: events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{CognitoIdentityID: "identity"}},
+			}, events.APIGatewayProxyResponse{}),
+			"", "identity", "",
+		},
+		{
+			"HTTP API JWT authorizer",
+			getUserIdV2HTTP(events.APIGatewayV2HTTPRequest{RequestContext: events.APIGatewayV2HTTPRequestContext{
+				Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+					Claims: map[string]string{"sub": "jwt-user"},
+				}},
+			}}, events.APIGatewayV2HTTPResponse{}),
+			getCompanyIdV2HTTP(events.APIGatewayV2HTTPRequest{RequestContext: events.APIGatewayV2HTTPRequestContext{
+				Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{Lambda: map[string]interface{}{"org_id": "lambda-org"}},
+			}}, events.APIGatewayV2HTTPResponse{}),
+			"jwt-user", "lambda-org",
+		},
+		{
+			"Bearer token",
+			getUserIdFunctionURL(events.LambdaFunctionURLRequest{Headers: map[string]string{"authorization": "bearer " + token}}, events.LambdaFunctionURLResponse{}),
+			getCompanyIdFunctionURL(events.LambdaFunctionURLRequest{Headers: map[string]string{"authorization": "bearer " + token}}, events.LambdaFunctionURLResponse{}),
+			"token-user", "42",
+		},
+		{
+			"ALB OIDC claims",
+			getUserIdALB(events.ALBTargetGroupRequest{Headers: map[string]string{"x-amzn-oidc-data": token}}, events.ALBTargetGroupResponse{}),
+			"", "token-user", "",
+		},
+		{
+			"Malformed token",
+			getUserIdWebsocket(events.APIGatewayWebsocketProxyRequest{Headers: map[string]string{"Authorization": "Bearer not.a-token"}}, events.APIGatewayProxyResponse{}),
+			"", "", "",
+		},
+	}
+
+	for _, test := range tests {
+		var userId string
+		if test.userId != nil {
+			userId = *test.userId
+		}
+		if userId != test.expected || test.companyId != test.company {
+			t.Errorf("got user %q and company %q for the %s, want %q and %q", userId, test.companyId, test.name, test.expected, test.company)
+		}
+	}
+
+	// Identification by the claims can be turned off
+	resetClient()
+	config, _ = NewConfig(MoesifOptions(), WithUserIdClaims())
+	moesifClient(config)
+	if userId := getUserIdFunctionURL(events.LambdaFunctionURLRequest{Headers: map[string]string{"Authorization": "Bearer " + token}}, events.LambdaFunctionURLResponse{}); userId != nil {
+		t.Errorf("got user %q, want none without claims", *userId)
+	}
+}
+
+func TestCompaniesAreIdentifiedByDefault(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions()).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	withApiKey := generateProxyReq(nil, false)
+	withApiKey.RequestContext.Identity.APIKeyID = "api-key"
+	withTenant := generateProxyReq(nil, false)
+	withTenant.RequestContext.Identity.APIKeyID = "api-key"
+	withTenant.RequestContext.Authorizer = map[string]interface{}{"principalId": "user", "tenant_id": "tenant"}
+	anonymous := generateProxyReq(nil, false)
+	anonymous.RequestContext.Identity = events.APIGatewayRequestIdentity{}
+
+	expected := []string{"api-key", "tenant", ""}
+	for _, request := range []events.APIGatewayProxyRequest{withApiKey, withTenant, anonymous} {
+		if _, err := handler(context.Background(), request); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	sent := testAPI.events()
+	if len(sent) != len(expected) {
+		t.Fatalf("got %d events sent, want %d", len(sent), len(expected))
+	}
+	for i, companyId := range expected {
+		if companyId == "" {
+			// Unset identities are sent as nil rather than empty strings
+			if sent[i].CompanyId != nil || sent[i].UserId != nil || sent[i].SessionToken != nil {
+				t.Errorf("got company %v, user %v and session %v, want none", sent[i].CompanyId, sent[i].UserId, sent[i].SessionToken)
+			}
+		} else if sent[i].CompanyId == nil || *sent[i].CompanyId != companyId {
+			t.Errorf("got company %v for the event %d, want %q", sent[i].CompanyId, i, companyId)
+		}
+	}
+}
@@ -1,115 +1,206 @@
 package moesifawslambda
 
- import (
-	 "net"
-	 "strings"
- )
- 
- func validIp(ipAddress string) bool {
-	 ip := net.ParseIP(ipAddress)
-	 return ip.To4() != nil || ip.To16()!= nil 
- }
- 
- func getClientIpFromXForwardedFor(ipAddress string) string {
-	 
-	 // Split the string
-	 ips := strings.Split(ipAddress, ", ")
-	 
-	 // Sometimes IP addresses in this header can be 'unknown' (http://stackoverflow.com/a/11285650).
-	 // Therefore taking the left-most IP address that is not unknown
-	 // A Squid configuration directive can also set the value to "unknown" (http://www.squid-cache.org/Doc/config/forwarded_for/)
-	 for _, ip := range ips {
-		 
-		 // Azure Web App's also adds a port for some reason, so we'll only use the first part (the IP)
-		 if strings.Contains(ip, ":") {
-			 ip = strings.Split(ip, ":")[0]
-		 }
- 
-		 // x-forwarded-for may return multiple IP addresses in the format:
-		 // "client IP, proxy 1 IP, proxy 2 IP"
-		 // Therefore, the right-most IP address is the IP address of the most recent proxy
-		 // and the left-most IP address is the IP address of the originating client.
-		 // source: http://docs.aws.amazon.com/elasticloadbalancing/latest/classic/x-forwarded-headers.html
-		 if validIp(ip) {
-			 return ip
-		 }
-		 
-	 }
-	 // Return empty String
-	 return ""
- }
- 
- func getClientIp(requestHeaders map[string] []string, defaultSourceIp *string) *string {
- 
-	 // Standard headers used by Amazon EC2, Heroku, and others.
-	 if xc, ok := requestHeaders["X-Client-Ip"]; ok {
-		 if validIp(xc[0]) {
-			 return &xc[0]
-		 }
-	 }
- 
-	 // Load-balancers (AWS ELB) or proxies.
-	 if lb, ok := requestHeaders["X-Forwarded-For"]; ok {
-		 xForwardedFor := getClientIpFromXForwardedFor(lb[0])
-		 if validIp(xForwardedFor) {
-			 return &xForwardedFor
-		 }
-	 }
- 
-	 // Cloudflare.
-	 // @see https://support.cloudflare.com/hc/en-us/articles/200170986-How-does-Cloudflare-handle-HTTP-Request-headers-
-	 // CF-Connecting-IP - applied to every request to the origin.
-	 if cf, ok := requestHeaders["Cf-Connecting-Ip"]; ok {
-		 if validIp(cf[0]) {
-			 return &cf[0]
-		 }
-	 }
- 
-	 // Akamai and Cloudflare: True-Client-IP.
-	 if tr, ok := requestHeaders["True-Client-Ip"]; ok {
-		 if validIp(tr[0]) {
-			 return &tr[0]
-		 }
-	 }
- 
-	 // Default nginx proxy/fcgi; alternative to x-forwarded-for, used by some proxies.
-	 if ngx, ok := requestHeaders["X-Real-Ip"]; ok {
-		 if validIp(ngx[0]) {
-			 return &ngx[0]
-		 }
-	 }
- 
-	 // (Rackspace LB and Riverbed's Stingray)
-	 // http://www.rackspace.com/knowledge_center/article/controlling-access-to-linux-cloud-sites-based-on-the-client-ip-address
-	 // https://splash.riverbed.com/docs/DOC-1926
-	 if rs, ok := requestHeaders["X-Cluster-Client-Ip"]; ok {
-		 if validIp(rs[0]) {
-			 return &rs[0]
-		 }
-	 }
-	 
-	 if xf, ok := requestHeaders["X-Forwarded"]; ok {
-		 if validIp(xf[0]) {
-			 return &xf[0]
-		 }
-	 }
- 
-	 if ff, ok := requestHeaders["Forwarded-For"]; ok {
-		 if validIp(ff[0]) {
-			 return &ff[0]
-		 }
-	 }
- 
-	 if f, ok := requestHeaders["Forwarded"]; ok {
-		 if validIp(f[0]) {
-			 return &f[0]
-		 }
-	 }
- 
-	 // Default Address
-	 if defaultSourceIp != nil {
-		 return defaultSourceIp
-	 } else {
-		 return nil
-	 }
- }
\ No newline at end of file
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies trusted by default: the loopback, private and link-local networks
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16",
+	"::1/128", "fc00::/7", "fe80::/10",
+}
+
+// Networks of the trusted proxies, parsed from Trusted_Proxies
+var trustedProxies []*net.IPNet
+
+// Headers holding the address of the client, set by a single proxy such as a CDN or a load balancer.
+// They are only used when the request reached the function through trusted proxies
+var clientIpHeaders = []string{
+	"X-Client-Ip",
+	// Cloudflare.
+	// @see https://support.cloudflare.com/hc/en-us/articles/200170986-How-does-Cloudflare-handle-HTTP-Request-headers-
+	// CF-Connecting-IP - applied to every request to the origin.
+	"Cf-Connecting-Ip",
+	// Akamai and Cloudflare: True-Client-IP.
+	"True-Client-Ip",
+	// Default nginx proxy/fcgi; alternative to x-forwarded-for, used by some proxies.
+	"X-Real-Ip",
+	// (Rackspace LB and Riverbed's Stingray)
+	// http://www.rackspace.com/knowledge_center/article/controlling-access-to-linux-cloud-sites-based-on-the-client-ip-address
+	"X-Cluster-Client-Ip",
+	"X-Forwarded",
+	"Forwarded-For",
+}
+
+// Parse the trusted proxies, given as CIDR blocks or single addresses
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: proxy}
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func isTrustedProxy(ipAddress string) bool {
+	ip := net.ParseIP(ipAddress)
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse an address that may carry a port, with IPv6 addresses in brackets when they do.
+// Returns "" when it is not an IP address, such as "unknown" or an obfuscated identifier
+func parseIp(address string) string {
+	address = strings.Trim(strings.TrimSpace(address), `"`)
+	if strings.HasPrefix(address, "[") {
+		end := strings.Index(address, "]")
+		if end < 0 {
+			return ""
+		}
+		address = address[1:end]
+	} else if strings.Count(address, ":") == 1 {
+		// An IPv4 address and its port, as Azure Web Apps add it
+		address = address[:strings.Index(address, ":")]
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// The addresses of the Forwarded header (RFC 7239), from the client to the most recent proxy
+func parseForwarded(values []string) []string {
+	var addresses []string
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			for _, pair := range splitQuoted(element, ';') {
+				name, address, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					addresses = append(addresses, parseIp(address))
+				}
+			}
+		}
+	}
+	return addresses
+}
+
+// Split a header value on the separator, except within quoted strings
+func splitQuoted(value string, separator byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quoted:
+			i++
+		case value[i] == '"':
+			quoted = !quoted
+		case value[i] == separator && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// The addresses of the X-Forwarded-For header, from the client to the most recent proxy
+func parseXForwardedFor(values []string) []string {
+	var addresses []string
+	for _, value := range values {
+		for _, address := range strings.Split(value, ",") {
+			addresses = append(addresses, parseIp(address))
+		}
+	}
+	return addresses
+}
+
+// The chain of addresses the request went through, from the client to the address that reached the function.
+// Addresses that are not IP addresses are kept as "" so that they can't be mistaken for the client
+func forwardedChain(headers http.Header, sourceIp string) []string {
+	var chain []string
+	if forwarded := headers.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwarded(forwarded)
+	} else {
+		chain = parseXForwardedFor(headers.Values("X-Forwarded-For"))
+	}
+	// API Gateway and Function URLs append the source address of the request themselves
+	if sourceIp != "" && (len(chain) == 0 || chain[len(chain)-1] != sourceIp) {
+		chain = append(chain, sourceIp)
+	}
+	return chain
+}
+
+// Resolve the address of the client. The chain of forwarded addresses is walked from the most recent proxy,
+// the first address that is not a trusted proxy is the client, as the addresses on its left may be forged by it
+func resolveClientIp(headers http.Header, sourceIp string) string {
+	chain := forwardedChain(headers, sourceIp)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i] == "" {
+			// The proxy that added this entry hides the client
+			return ""
+		}
+		if !isTrustedProxy(chain[i]) {
+			return chain[i]
+		}
+	}
+
+	// Every proxy is trusted, their headers are too
+	for _, name := range clientIpHeaders {
+		if ip := parseIp(headers.Get(name)); ip != "" {
+			return ip
+		}
+	}
+	for _, ip := range chain {
+		if ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+func getClientIp(requestHeaders map[string][]string, defaultSourceIp *string) *string {
+	// Lookups are case insensitive, HTTP APIs and load balancers send the headers in lower case
+	headers := make(http.Header, len(requestHeaders))
+	for name, values := range requestHeaders {
+		key := http.CanonicalHeaderKey(name)
+		headers[key] = append(headers[key], values...)
+	}
+
+	var sourceIp string
+	if defaultSourceIp != nil {
+		sourceIp = parseIp(*defaultSourceIp)
+	}
+
+	if moesifConfig.IdentifyIp != nil {
+		if ip := moesifConfig.IdentifyIp(headers, sourceIp); ip != "" {
+			return &ip
+		}
+	}
+
+	if ip := resolveClientIp(headers, sourceIp); ip != "" {
+		return &ip
+	}
+	// Default Address
+	return defaultSourceIp
+}
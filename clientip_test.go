@@ -0,0 +1,98 @@
+package moesifawslambda
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestParseIp(t *testing.T) {
+	tests := map[string]string{
+		"72.12.164.125":            "72.12.164.125",
+		" 72.12.164.125:8080 ":     "72.12.164.125",
+		"2001:db8:cafe::17":        "2001:db8:cafe::17",
+		"[2001:db8:cafe::17]:4711": "2001:db8:cafe::17",
+		`"[2001:db8:cafe::17]"`:    "2001:db8:cafe::17",
+		"unknown":                  "",
+		"_hidden":                  "",
+		"[2001:db8:cafe::17":       "",
+		"2001:db8:cafe::17%eth0":   "",
+		"::ffff:72.12.164.125":     "72.12.164.125",
+	}
+	for address, expected := range tests {
+		if ip := parseIp(address); ip != expected {
+			t.Errorf("got %q for %q, want %q", ip, address, expected)
+		}
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	forwarded := []string{`for=192.0.2.60;proto=http;by=203.0.113.43, For="[2001:db8:cafe::17]:4711"`, `for=unknown;host="a,b"`}
+	expected := []string{"192.0.2.60", "2001:db8:cafe::17", ""}
+	if addresses := parseForwarded(forwarded); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("got %v, want %v", addresses, expected)
+	}
+}
+
+func TestResolveClientIp(t *testing.T) {
+	defer func(proxies []*net.IPNet) { trustedProxies = proxies }(trustedProxies)
+	trustedProxies, _ = parseTrustedProxies(append([]string{"203.0.113.0/24"}, defaultTrustedProxies...))
+
+	tests := []struct {
+		headers  map[string]string
+		sourceIp string
+		expected string
+	}{
+		// The client can't forge the addresses on the right of its own
+		{map[string]string{"x-forwarded-for": "1.1.1.1, 72.12.164.125"}, "72.12.164.125", "72.12.164.125"},
+		{map[string]string{"X-Forwarded-For": "1.1.1.1"}, "72.12.164.125", "72.12.164.125"},
+		// Trusted proxies are skipped
+		{map[string]string{"X-Forwarded-For": "72.12.164.125, 10.0.0.1, 203.0.113.7"}, "", "72.12.164.125"},
+		{map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711", for=10.0.0.1`, "X-Forwarded-For": "1.1.1.1"}, "", "2001:db8:cafe::17"},
+		// The headers of trusted proxies are used
+		{map[string]string{"cf-connecting-ip": "72.12.164.125"}, "203.0.113.7", "72.12.164.125"},
+		{map[string]string{"cf-connecting-ip": "72.12.164.125"}, "1.1.1.1", "1.1.1.1"},
+		// A proxy hides the client
+		{map[string]string{"Forwarded": "for=_hidden, for=10.0.0.1", "X-Real-Ip": "72.12.164.125"}, "", ""},
+	}
+
+	for _, test := range tests {
+		headers := http.Header{}
+		for name, value := range test.headers {
+			headers.Set(name, value)
+		}
+		if ip := resolveClientIp(headers, test.sourceIp); ip != test.expected {
+			t.Errorf("got %q for %v from %q, want %q", ip, test.headers, test.sourceIp, test.expected)
+		}
+	}
+}
+
+func TestMoesifLoggerIdentifiesIp(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEventV2HTTP, MoesifOptions(),
+		WithIdentifyIp(func(headers http.Header, sourceIp string) string {
+			return headers.Get("X-Origin-Ip")
+		})).(func(context.Context, events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error))
+
+	request := generateProxyReqV2HTTP(nil, false)
+	request.Headers = map[string]string{"x-origin-ip": "72.12.164.125"}
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if ip := sent[0].Request.IpAddress; ip == nil || *ip != "72.12.164.125" {
+		t.Errorf("got ip %v, want the address identified by Identify_Ip", ip)
+	}
+
+	if _, err := NewConfig(nil, WithTrustedProxies("10.0.0.0/33")); err == nil {
+		t.Errorf("expected an error for an invalid trusted proxy")
+	}
+}
@@ -0,0 +1,36 @@
+// Command moesif-lambda-extension is a Lambda Extension sending the events captured by
+// MoesifLogger in extension mode to Moesif after the function has returned its response.
+//
+// Build it for the Lambda architecture and package it in a layer under extensions/:
+//
+//	GOOS=linux go build -o extensions/moesif-lambda-extension ./cmd/moesif-lambda-extension
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/moesif/moesif-aws-lambda-go/extension"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	options := extension.Options{
+		Debug: os.Getenv("MOESIF_DEBUG") == "true",
+	}
+	if err := extension.Run(ctx, options); err != nil {
+		log.Fatalf("Moesif extension stopped: %s.\n", err.Error())
+	}
+}
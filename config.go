@@ -0,0 +1,788 @@
+package moesifawslambda
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/moesif/moesif-aws-lambda-go/extension"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// Callbacks applied to API Gateway REST API events (payload format 1.0)
+type APIGatewayProxyCallbacks struct {
+	ShouldSkip      func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) bool
+	IdentifyUser    func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string
+	IdentifyCompany func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string
+	GetMetadata     func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) map[string]interface{}
+	GetSessionToken func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string
+}
+
+// Callbacks applied to API Gateway HTTP API events (payload format 2.0)
+type APIGatewayV2HTTPCallbacks struct {
+	ShouldSkip      func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) bool
+	IdentifyUser    func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string
+	IdentifyCompany func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string
+	GetMetadata     func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) map[string]interface{}
+	GetSessionToken func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string
+}
+
+// Callbacks applied to Application Load Balancer target group events
+type ALBTargetGroupCallbacks struct {
+	ShouldSkip      func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) bool
+	IdentifyUser    func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) string
+	IdentifyCompany func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) string
+	GetMetadata     func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) map[string]interface{}
+	GetSessionToken func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) string
+}
+
+// Callbacks applied to Lambda Function URL events
+type LambdaFunctionURLCallbacks struct {
+	ShouldSkip      func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) bool
+	IdentifyUser    func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) string
+	IdentifyCompany func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) string
+	GetMetadata     func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) map[string]interface{}
+	GetSessionToken func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) string
+}
+
+// Callbacks applied to API Gateway WebSocket API events
+type APIGatewayWebsocketCallbacks struct {
+	ShouldSkip      func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) bool
+	IdentifyUser    func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) string
+	IdentifyCompany func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) string
+	GetMetadata     func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) map[string]interface{}
+	GetSessionToken func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) string
+}
+
+// Callbacks applied to outgoing API calls
+type OutgoingCallbacks struct {
+	ShouldSkip      func(*http.Request, *http.Response) bool
+	IdentifyUser    func(*http.Request, *http.Response) string
+	IdentifyCompany func(*http.Request, *http.Response) string
+	GetMetadata     func(*http.Request, *http.Response) map[string]interface{}
+	GetSessionToken func(*http.Request, *http.Response) string
+}
+
+// Config is the typed configuration of the middleware
+type Config struct {
+	Debug           bool
+	LogBody         bool
+	LogBodyOutgoing bool
+	ApiVersion      string
+
+	// Resolve the version of the API from the stage, the path or a header instead of using ApiVersion.
+	// IdentifyApiVersion overrides the strategy when it returns a version
+	ApiVersionFrom        string
+	ApiVersionPathPattern string
+	ApiVersionHeader      string
+	IdentifyApiVersion    func(path string, stage string, headers http.Header) string
+
+	// Sample the events with the rates of the application config fetched from Moesif
+	Sampling bool
+
+	// Identify the users and companies without Identify_User or Identify_Company by the first of these claims set
+	// by the authorizer, or else in the bearer token of the request. Empty to not identify them by their claims
+	UserIdClaims    []string
+	CompanyIdClaims []string
+
+	// Identify the company by the API key of the usage plan of REST API and WebSocket API requests when the claims don't
+	CompanyFromApiKey bool
+
+	// Apply the governance rules fetched from Moesif before calling the handler, or only annotate the events in dry run
+	Governance string
+
+	// Reject the requests of the users and companies over these many requests per window with a 429, 0 for no limit.
+	// The requests are counted in RateLimitStore, in the memory of the instance of the function by default
+	RateLimitUser    int
+	RateLimitCompany int
+	RateLimitWindow  time.Duration
+	RateLimitStore   RateLimitStore
+
+	// Replace binary bodies by a summary of their type and size
+	SummarizeBinaryBodies bool
+
+	// Record the route template matched by API Gateway in the metadata, or also in place of the path of the URI
+	RouteTemplate string
+
+	// Proxies whose forwarding headers are trusted to resolve the address of the client, as CIDR blocks or addresses.
+	// IdentifyIp overrides the resolution when it returns an address
+	TrustedProxies []string
+	IdentifyIp     func(headers http.Header, sourceIp string) string
+
+	// Truncate the bodies larger than these sizes in bytes, 0 for no limit
+	MaxBodySize         int
+	MaxBodySizeOutgoing int
+
+	// Decompress the bodies according to their Content-Encoding, up to this size in bytes
+	DecompressBodies        bool
+	MaxDecompressedBodySize int
+
+	// Error handling when Moesif can't be reached
+	ErrorPolicy   string
+	RetryAttempts int
+	SpoolSize     int
+	OnError       func(error)
+
+	// Batching of the events sent to Moesif
+	BatchSize    int
+	MaxQueueSize int
+	FlushTimeout time.Duration
+
+	// Hand the events to the Moesif Lambda Extension instead of sending them to Moesif
+	ExtensionMode    bool
+	ExtensionAddress string
+
+	// Log the invocations failing with an error or a panic with the response the client receives
+	CaptureErrors   bool
+	ErrorStatusCode int
+
+	// Record the Lambda context of the invocation in the metadata, all the fields when none are selected
+	LogLambdaContext    bool
+	LambdaContextFields []string
+
+	// Replace the values of these headers, query parameters and JSON body fields with the placeholder
+	RedactHeaders        []string
+	RedactQueryParams    []string
+	RedactBodyFields     []string
+	RedactionPlaceholder string
+
+	// Scrub the PII found by the detectors in the string values of the bodies, all the detectors when none are selected
+	ScrubPII     bool
+	PIIDetectors []string
+	PIIAction    string
+	// The key of the hashes of the PII, a random key of the instance by default
+	PIIHashKey string
+
+	MaskEventModel         func(models.EventModel) models.EventModel
+	MaskEventModelOutgoing func(models.EventModel) models.EventModel
+
+	APIGatewayProxy     APIGatewayProxyCallbacks
+	APIGatewayV2HTTP    APIGatewayV2HTTPCallbacks
+	ALBTargetGroup      ALBTargetGroupCallbacks
+	LambdaFunctionURL   LambdaFunctionURLCallbacks
+	APIGatewayWebsocket APIGatewayWebsocketCallbacks
+	Outgoing            OutgoingCallbacks
+}
+
+// Option configures the middleware
+type Option func(*Config)
+
+func WithDebug(enabled bool) Option {
+	return func(c *Config) { c.Debug = enabled }
+}
+
+func WithLogBody(enabled bool) Option {
+	return func(c *Config) { c.LogBody = enabled }
+}
+
+func WithLogBodyOutgoing(enabled bool) Option {
+	return func(c *Config) { c.LogBodyOutgoing = enabled }
+}
+
+func WithSampling(enabled bool) Option {
+	return func(c *Config) { c.Sampling = enabled }
+}
+
+func WithGovernance(mode string) Option {
+	return func(c *Config) { c.Governance = mode }
+}
+
+func WithUserIdClaims(names ...string) Option {
+	return func(c *Config) { c.UserIdClaims = names }
+}
+
+func WithCompanyIdClaims(names ...string) Option {
+	return func(c *Config) { c.CompanyIdClaims = names }
+}
+
+func WithCompanyFromApiKey(enabled bool) Option {
+	return func(c *Config) { c.CompanyFromApiKey = enabled }
+}
+
+func WithRateLimitUser(limit int) Option {
+	return func(c *Config) { c.RateLimitUser = limit }
+}
+
+func WithRateLimitCompany(limit int) Option {
+	return func(c *Config) { c.RateLimitCompany = limit }
+}
+
+func WithRateLimitWindow(window time.Duration) Option {
+	return func(c *Config) { c.RateLimitWindow = window }
+}
+
+func WithRateLimitStore(store RateLimitStore) Option {
+	return func(c *Config) { c.RateLimitStore = store }
+}
+
+func WithSummarizeBinaryBodies(enabled bool) Option {
+	return func(c *Config) { c.SummarizeBinaryBodies = enabled }
+}
+
+func WithRouteTemplate(mode string) Option {
+	return func(c *Config) { c.RouteTemplate = mode }
+}
+
+func WithTrustedProxies(proxies ...string) Option {
+	return func(c *Config) { c.TrustedProxies = proxies }
+}
+
+func WithIdentifyIp(identifyIp func(headers http.Header, sourceIp string) string) Option {
+	return func(c *Config) { c.IdentifyIp = identifyIp }
+}
+
+func WithMaxBodySize(size int) Option {
+	return func(c *Config) { c.MaxBodySize = size }
+}
+
+func WithMaxBodySizeOutgoing(size int) Option {
+	return func(c *Config) { c.MaxBodySizeOutgoing = size }
+}
+
+func WithDecompressBodies(enabled bool) Option {
+	return func(c *Config) { c.DecompressBodies = enabled }
+}
+
+func WithMaxDecompressedBodySize(size int) Option {
+	return func(c *Config) { c.MaxDecompressedBodySize = size }
+}
+
+func WithApiVersion(version string) Option {
+	return func(c *Config) { c.ApiVersion = version }
+}
+
+func WithApiVersionFrom(strategy string) Option {
+	return func(c *Config) { c.ApiVersionFrom = strategy }
+}
+
+func WithApiVersionPathPattern(pattern string) Option {
+	return func(c *Config) { c.ApiVersionPathPattern = pattern }
+}
+
+func WithApiVersionHeader(header string) Option {
+	return func(c *Config) { c.ApiVersionHeader = header }
+}
+
+func WithIdentifyApiVersion(identifyApiVersion func(path string, stage string, headers http.Header) string) Option {
+	return func(c *Config) { c.IdentifyApiVersion = identifyApiVersion }
+}
+
+func WithErrorPolicy(policy string) Option {
+	return func(c *Config) { c.ErrorPolicy = policy }
+}
+
+func WithRetryAttempts(attempts int) Option {
+	return func(c *Config) { c.RetryAttempts = attempts }
+}
+
+func WithSpoolSize(size int) Option {
+	return func(c *Config) { c.SpoolSize = size }
+}
+
+func WithOnError(callback func(error)) Option {
+	return func(c *Config) { c.OnError = callback }
+}
+
+func WithBatchSize(size int) Option {
+	return func(c *Config) { c.BatchSize = size }
+}
+
+func WithMaxQueueSize(size int) Option {
+	return func(c *Config) { c.MaxQueueSize = size }
+}
+
+func WithFlushTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.FlushTimeout = timeout }
+}
+
+func WithExtensionMode(enabled bool) Option {
+	return func(c *Config) { c.ExtensionMode = enabled }
+}
+
+func WithExtensionAddress(address string) Option {
+	return func(c *Config) { c.ExtensionAddress = address }
+}
+
+func WithCaptureErrors(enabled bool) Option {
+	return func(c *Config) { c.CaptureErrors = enabled }
+}
+
+func WithErrorStatusCode(statusCode int) Option {
+	return func(c *Config) { c.ErrorStatusCode = statusCode }
+}
+
+func WithLambdaContext(enabled bool) Option {
+	return func(c *Config) { c.LogLambdaContext = enabled }
+}
+
+func WithLambdaContextFields(fields ...string) Option {
+	return func(c *Config) { c.LambdaContextFields = fields }
+}
+
+func WithRedactHeaders(names ...string) Option {
+	return func(c *Config) { c.RedactHeaders = names }
+}
+
+func WithRedactQueryParams(names ...string) Option {
+	return func(c *Config) { c.RedactQueryParams = names }
+}
+
+func WithRedactBodyFields(selectors ...string) Option {
+	return func(c *Config) { c.RedactBodyFields = selectors }
+}
+
+func WithRedactionPlaceholder(placeholder string) Option {
+	return func(c *Config) { c.RedactionPlaceholder = placeholder }
+}
+
+func WithScrubPII(enabled bool) Option {
+	return func(c *Config) { c.ScrubPII = enabled }
+}
+
+func WithPIIDetectors(detectors ...string) Option {
+	return func(c *Config) { c.PIIDetectors = detectors }
+}
+
+func WithPIIAction(action string) Option {
+	return func(c *Config) { c.PIIAction = action }
+}
+
+func WithPIIHashKey(key string) Option {
+	return func(c *Config) { c.PIIHashKey = key }
+}
+
+func WithMaskEventModel(mask func(models.EventModel) models.EventModel) Option {
+	return func(c *Config) { c.MaskEventModel = mask }
+}
+
+func WithMaskEventModelOutgoing(mask func(models.EventModel) models.EventModel) Option {
+	return func(c *Config) { c.MaskEventModelOutgoing = mask }
+}
+
+func WithAPIGatewayProxyCallbacks(callbacks APIGatewayProxyCallbacks) Option {
+	return func(c *Config) { c.APIGatewayProxy = callbacks }
+}
+
+func WithAPIGatewayV2HTTPCallbacks(callbacks APIGatewayV2HTTPCallbacks) Option {
+	return func(c *Config) { c.APIGatewayV2HTTP = callbacks }
+}
+
+func WithALBTargetGroupCallbacks(callbacks ALBTargetGroupCallbacks) Option {
+	return func(c *Config) { c.ALBTargetGroup = callbacks }
+}
+
+func WithLambdaFunctionURLCallbacks(callbacks LambdaFunctionURLCallbacks) Option {
+	return func(c *Config) { c.LambdaFunctionURL = callbacks }
+}
+
+func WithAPIGatewayWebsocketCallbacks(callbacks APIGatewayWebsocketCallbacks) Option {
+	return func(c *Config) { c.APIGatewayWebsocket = callbacks }
+}
+
+func WithOutgoingCallbacks(callbacks OutgoingCallbacks) Option {
+	return func(c *Config) { c.Outgoing = callbacks }
+}
+
+// Build the configuration from the legacy options map and the functional options.
+// The map may be nil; options are applied after it.
+func NewConfig(configurationOption map[string]interface{}, opts ...Option) (*Config, error) {
+	config := &Config{
+		LogBody:               true,
+		LogBodyOutgoing:       true,
+		ApiVersionFrom:        ApiVersionFromStatic,
+		ApiVersionPathPattern: defaultApiVersionPathPattern,
+		ApiVersionHeader:      defaultApiVersionHeader,
+		RouteTemplate:         RouteTemplateMetadata,
+		Sampling:              true,
+		Governance:            GovernanceOff,
+		UserIdClaims:          append([]string(nil), defaultUserIdClaims...),
+		CompanyIdClaims:       append([]string(nil), defaultCompanyIdClaims...),
+		CompanyFromApiKey:     true,
+		RateLimitWindow:       defaultRateLimitWindow,
+		// Copied so that changing the proxies of a configuration leaves the defaults untouched
+		TrustedProxies:          append([]string(nil), defaultTrustedProxies...),
+		MaxBodySize:             defaultMaxBodySize,
+		MaxBodySizeOutgoing:     defaultMaxBodySize,
+		DecompressBodies:        true,
+		MaxDecompressedBodySize: defaultMaxDecompressedBodySize,
+		ErrorPolicy:             ErrorPolicyDrop,
+		RetryAttempts:           defaultRetryAttempts,
+		SpoolSize:               defaultSpoolSize,
+		BatchSize:               defaultBatchSize,
+		MaxQueueSize:            defaultMaxQueueSize,
+		FlushTimeout:            defaultFlushTimeout,
+		ExtensionAddress:        extension.DefaultAddress,
+		CaptureErrors:           true,
+		ErrorStatusCode:         defaultErrorStatusCode,
+		LogLambdaContext:        true,
+		// Copied so that changing the headers of a configuration leaves the defaults untouched
+		RedactHeaders:        append([]string(nil), defaultRedactedHeaders...),
+		RedactionPlaceholder: defaultRedactionPlaceholder,
+		PIIAction:            PIIActionRedact,
+	}
+
+	if err := config.applyMap(configurationOption); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Translate the legacy options map, checking the type of every known option
+func (c *Config) applyMap(configurationOption map[string]interface{}) error {
+	for key, value := range configurationOption {
+		var ok bool
+		switch key {
+		case "Debug":
+			c.Debug, ok = value.(bool)
+		case "Log_Body":
+			c.LogBody, ok = value.(bool)
+		case "Log_Body_Outgoing":
+			c.LogBodyOutgoing, ok = value.(bool)
+		case "Api_Version":
+			c.ApiVersion, ok = value.(string)
+		case "Api_Version_From":
+			c.ApiVersionFrom, ok = value.(string)
+		case "Api_Version_Path_Pattern":
+			c.ApiVersionPathPattern, ok = value.(string)
+		case "Api_Version_Header":
+			c.ApiVersionHeader, ok = value.(string)
+		case "Identify_Api_Version":
+			c.IdentifyApiVersion, ok = value.(func(string, string, http.Header) string)
+		case "Error_Policy":
+			c.ErrorPolicy, ok = value.(string)
+		case "Retry_Attempts":
+			c.RetryAttempts, ok = value.(int)
+		case "Spool_Size":
+			c.SpoolSize, ok = value.(int)
+		case "Batch_Size":
+			c.BatchSize, ok = value.(int)
+		case "Max_Queue_Size":
+			c.MaxQueueSize, ok = value.(int)
+		case "Flush_Timeout":
+			c.FlushTimeout, ok = value.(time.Duration)
+		case "Extension_Mode":
+			c.ExtensionMode, ok = value.(bool)
+		case "Extension_Address":
+			c.ExtensionAddress, ok = value.(string)
+		case "Capture_Errors":
+			c.CaptureErrors, ok = value.(bool)
+		case "Error_Status_Code":
+			c.ErrorStatusCode, ok = value.(int)
+		case "Log_Lambda_Context":
+			c.LogLambdaContext, ok = value.(bool)
+		case "Lambda_Context_Fields":
+			c.LambdaContextFields, ok = value.([]string)
+		case "On_Error":
+			c.OnError, ok = value.(func(error))
+		case "Redact_Headers":
+			c.RedactHeaders, ok = value.([]string)
+		case "Redact_Query_Params":
+			c.RedactQueryParams, ok = value.([]string)
+		case "Redact_Body_Fields":
+			c.RedactBodyFields, ok = value.([]string)
+		case "Redaction_Placeholder":
+			c.RedactionPlaceholder, ok = value.(string)
+		case "Scrub_PII":
+			c.ScrubPII, ok = value.(bool)
+		case "PII_Detectors":
+			c.PIIDetectors, ok = value.([]string)
+		case "PII_Action":
+			c.PIIAction, ok = value.(string)
+		case "PII_Hash_Key":
+			c.PIIHashKey, ok = value.(string)
+		case "Sampling":
+			c.Sampling, ok = value.(bool)
+		case "Governance":
+			c.Governance, ok = value.(string)
+		case "User_Id_Claims":
+			c.UserIdClaims, ok = value.([]string)
+		case "Company_Id_Claims":
+			c.CompanyIdClaims, ok = value.([]string)
+		case "Company_From_Api_Key":
+			c.CompanyFromApiKey, ok = value.(bool)
+		case "Rate_Limit_User":
+			c.RateLimitUser, ok = value.(int)
+		case "Rate_Limit_Company":
+			c.RateLimitCompany, ok = value.(int)
+		case "Rate_Limit_Window":
+			c.RateLimitWindow, ok = value.(time.Duration)
+		case "Rate_Limit_Store":
+			c.RateLimitStore, ok = value.(RateLimitStore)
+		case "Summarize_Binary_Bodies":
+			c.SummarizeBinaryBodies, ok = value.(bool)
+		case "Route_Template":
+			c.RouteTemplate, ok = value.(string)
+		case "Trusted_Proxies":
+			c.TrustedProxies, ok = value.([]string)
+		case "Identify_Ip":
+			c.IdentifyIp, ok = value.(func(http.Header, string) string)
+		case "Max_Body_Size":
+			c.MaxBodySize, ok = value.(int)
+		case "Max_Body_Size_Outgoing":
+			c.MaxBodySizeOutgoing, ok = value.(int)
+		case "Decompress_Bodies":
+			c.DecompressBodies, ok = value.(bool)
+		case "Max_Decompressed_Body_Size":
+			c.MaxDecompressedBodySize, ok = value.(int)
+		case "Mask_Event_Model":
+			c.MaskEventModel, ok = value.(func(models.EventModel) models.EventModel)
+		case "Mask_Event_Model_Outgoing":
+			c.MaskEventModelOutgoing, ok = value.(func(models.EventModel) models.EventModel)
+		case "Should_Skip":
+			switch callback := value.(type) {
+			case func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) bool:
+				c.APIGatewayProxy.ShouldSkip, ok = callback, true
+			case func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) bool:
+				c.APIGatewayV2HTTP.ShouldSkip, ok = callback, true
+			case func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) bool:
+				c.ALBTargetGroup.ShouldSkip, ok = callback, true
+			case func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) bool:
+				c.LambdaFunctionURL.ShouldSkip, ok = callback, true
+			case func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) bool:
+				c.APIGatewayWebsocket.ShouldSkip, ok = callback, true
+			}
+		case "Identify_User":
+			switch callback := value.(type) {
+			case func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string:
+				c.APIGatewayProxy.IdentifyUser, ok = callback, true
+			case func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string:
+				c.APIGatewayV2HTTP.IdentifyUser, ok = callback, true
+			case func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) string:
+				c.ALBTargetGroup.IdentifyUser, ok = callback, true
+			case func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) string:
+				c.LambdaFunctionURL.IdentifyUser, ok = callback, true
+			case func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) string:
+				c.APIGatewayWebsocket.IdentifyUser, ok = callback, true
+			}
+		case "Identify_Company":
+			switch callback := value.(type) {
+			case func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string:
+				c.APIGatewayProxy.IdentifyCompany, ok = callback, true
+			case func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string:
+				c.APIGatewayV2HTTP.IdentifyCompany, ok = callback, true
+			case func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) string:
+				c.ALBTargetGroup.IdentifyCompany, ok = callback, true
+			case func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) string:
+				c.LambdaFunctionURL.IdentifyCompany, ok = callback, true
+			case func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) string:
+				c.APIGatewayWebsocket.IdentifyCompany, ok = callback, true
+			}
+		case "Get_Metadata":
+			switch callback := value.(type) {
+			case func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) map[string]interface{}:
+				c.APIGatewayProxy.GetMetadata, ok = callback, true
+			case func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) map[string]interface{}:
+				c.APIGatewayV2HTTP.GetMetadata, ok = callback, true
+			case func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) map[string]interface{}:
+				c.ALBTargetGroup.GetMetadata, ok = callback, true
+			case func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) map[string]interface{}:
+				c.LambdaFunctionURL.GetMetadata, ok = callback, true
+			case func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) map[string]interface{}:
+				c.APIGatewayWebsocket.GetMetadata, ok = callback, true
+			}
+		case "Get_Session_Token":
+			switch callback := value.(type) {
+			case func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string:
+				c.APIGatewayProxy.GetSessionToken, ok = callback, true
+			case func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string:
+				c.APIGatewayV2HTTP.GetSessionToken, ok = callback, true
+			case func(events.ALBTargetGroupRequest, events.ALBTargetGroupResponse) string:
+				c.ALBTargetGroup.GetSessionToken, ok = callback, true
+			case func(events.LambdaFunctionURLRequest, events.LambdaFunctionURLResponse) string:
+				c.LambdaFunctionURL.GetSessionToken, ok = callback, true
+			case func(events.APIGatewayWebsocketProxyRequest, events.APIGatewayProxyResponse) string:
+				c.APIGatewayWebsocket.GetSessionToken, ok = callback, true
+			}
+		case "Should_Skip_Outgoing":
+			c.Outgoing.ShouldSkip, ok = value.(func(*http.Request, *http.Response) bool)
+		case "Identify_User_Outgoing":
+			c.Outgoing.IdentifyUser, ok = value.(func(*http.Request, *http.Response) string)
+		case "Identify_Company_Outgoing":
+			c.Outgoing.IdentifyCompany, ok = value.(func(*http.Request, *http.Response) string)
+		case "Get_Metadata_Outgoing":
+			c.Outgoing.GetMetadata, ok = value.(func(*http.Request, *http.Response) map[string]interface{})
+		case "Get_Session_Token_Outgoing":
+			c.Outgoing.GetSessionToken, ok = value.(func(*http.Request, *http.Response) string)
+		default:
+			// Options such as Application_Id are not used by the middleware
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("option %s has unsupported type %T", key, value)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	switch c.ApiVersionFrom {
+	case ApiVersionFromStatic, ApiVersionFromStage, ApiVersionFromPath, ApiVersionFromHeader:
+	default:
+		return fmt.Errorf("unknown api version strategy %q", c.ApiVersionFrom)
+	}
+	if _, err := regexp.Compile(c.ApiVersionPathPattern); err != nil {
+		return fmt.Errorf("invalid api version path pattern: %s", err.Error())
+	}
+	if c.ApiVersionFrom == ApiVersionFromHeader && c.ApiVersionHeader == "" {
+		return fmt.Errorf("api version header must be set to take the version from a header")
+	}
+	switch c.ErrorPolicy {
+	case ErrorPolicyDrop, ErrorPolicyRetry, ErrorPolicySpool:
+	default:
+		return fmt.Errorf("unknown error policy %q", c.ErrorPolicy)
+	}
+	if c.RetryAttempts < 0 {
+		return fmt.Errorf("retry attempts must not be negative, got %d", c.RetryAttempts)
+	}
+	if c.SpoolSize < 0 {
+		return fmt.Errorf("spool size must not be negative, got %d", c.SpoolSize)
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive, got %d", c.BatchSize)
+	}
+	if c.MaxQueueSize < 0 {
+		return fmt.Errorf("max queue size must not be negative, got %d", c.MaxQueueSize)
+	}
+	if c.FlushTimeout <= 0 {
+		return fmt.Errorf("flush timeout must be positive, got %v", c.FlushTimeout)
+	}
+	switch c.Governance {
+	case GovernanceOff, GovernanceEnforce, GovernanceDryRun:
+	default:
+		return fmt.Errorf("unknown governance mode %q", c.Governance)
+	}
+	if c.RateLimitUser < 0 || c.RateLimitCompany < 0 {
+		return fmt.Errorf("rate limits must not be negative, got %d and %d", c.RateLimitUser, c.RateLimitCompany)
+	}
+	if c.RateLimitWindow <= 0 {
+		return fmt.Errorf("rate limit window must be positive, got %s", c.RateLimitWindow)
+	}
+	switch c.RouteTemplate {
+	case RouteTemplateMetadata, RouteTemplateURI, RouteTemplateNone:
+	default:
+		return fmt.Errorf("unknown route template mode %q", c.RouteTemplate)
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxy: %s", err.Error())
+	}
+	if c.MaxBodySize < 0 || c.MaxBodySizeOutgoing < 0 {
+		return fmt.Errorf("max body sizes must not be negative, got %d and %d", c.MaxBodySize, c.MaxBodySizeOutgoing)
+	}
+	if c.DecompressBodies && c.MaxDecompressedBodySize <= 0 {
+		return fmt.Errorf("max decompressed body size must be positive, got %d", c.MaxDecompressedBodySize)
+	}
+	if c.ExtensionMode && c.ExtensionAddress == "" {
+		return fmt.Errorf("extension address must be set in extension mode")
+	}
+	if c.CaptureErrors && (c.ErrorStatusCode < 100 || c.ErrorStatusCode > 599) {
+		return fmt.Errorf("error status code must be a valid HTTP status code, got %d", c.ErrorStatusCode)
+	}
+	for _, field := range c.LambdaContextFields {
+		if !contains(lambdaContextFields, field) {
+			return fmt.Errorf("unknown Lambda context field %q", field)
+		}
+	}
+	for _, selector := range c.RedactBodyFields {
+		if _, err := parseSelector(selector); err != nil {
+			return fmt.Errorf("invalid body field selector %q: %s", selector, err.Error())
+		}
+	}
+	for _, detector := range c.PIIDetectors {
+		if !contains(piiDetectorNames(), detector) {
+			return fmt.Errorf("unknown PII detector %q", detector)
+		}
+	}
+	if c.PIIAction != PIIActionRedact && c.PIIAction != PIIActionHash {
+		return fmt.Errorf("unknown PII action %q", c.PIIAction)
+	}
+	return nil
+}
+
+// Payloads received by the supported handlers
+const (
+	payloadAPIGatewayProxy  = "APIGatewayProxyRequest"
+	payloadAPIGatewayV2HTTP = "APIGatewayV2HTTPRequest"
+	payloadALBTargetGroup   = "ALBTargetGroupRequest"
+	payloadFunctionURL      = "LambdaFunctionURLRequest"
+	payloadWebsocket        = "APIGatewayWebsocketProxyRequest"
+)
+
+var incomingPayloads = []string{payloadAPIGatewayProxy, payloadAPIGatewayV2HTTP, payloadALBTargetGroup, payloadFunctionURL, payloadWebsocket}
+
+// Names of the callbacks set for the given payload
+func (c *Config) callbacksFor(payload string) []string {
+	switch payload {
+	case payloadAPIGatewayProxy:
+		callbacks := c.APIGatewayProxy
+		return configuredCallbacks(callbacks.ShouldSkip != nil, callbacks.IdentifyUser != nil, callbacks.IdentifyCompany != nil,
+			callbacks.GetMetadata != nil, callbacks.GetSessionToken != nil)
+	case payloadAPIGatewayV2HTTP:
+		callbacks := c.APIGatewayV2HTTP
+		return configuredCallbacks(callbacks.ShouldSkip != nil, callbacks.IdentifyUser != nil, callbacks.IdentifyCompany != nil,
+			callbacks.GetMetadata != nil, callbacks.GetSessionToken != nil)
+	case payloadALBTargetGroup:
+		callbacks := c.ALBTargetGroup
+		return configuredCallbacks(callbacks.ShouldSkip != nil, callbacks.IdentifyUser != nil, callbacks.IdentifyCompany != nil,
+			callbacks.GetMetadata != nil, callbacks.GetSessionToken != nil)
+	case payloadFunctionURL:
+		callbacks := c.LambdaFunctionURL
+		return configuredCallbacks(callbacks.ShouldSkip != nil, callbacks.IdentifyUser != nil, callbacks.IdentifyCompany != nil,
+			callbacks.GetMetadata != nil, callbacks.GetSessionToken != nil)
+	case payloadWebsocket:
+		callbacks := c.APIGatewayWebsocket
+		return configuredCallbacks(callbacks.ShouldSkip != nil, callbacks.IdentifyUser != nil, callbacks.IdentifyCompany != nil,
+			callbacks.GetMetadata != nil, callbacks.GetSessionToken != nil)
+	}
+	return nil
+}
+
+func configuredCallbacks(shouldSkip, identifyUser, identifyCompany, getMetadata, getSessionToken bool) []string {
+	var names []string
+	if shouldSkip {
+		names = append(names, "Should_Skip")
+	}
+	if identifyUser {
+		names = append(names, "Identify_User")
+	}
+	if identifyCompany {
+		names = append(names, "Identify_Company")
+	}
+	if getMetadata {
+		names = append(names, "Get_Metadata")
+	}
+	if getSessionToken {
+		names = append(names, "Get_Session_Token")
+	}
+	return names
+}
+
+// Check that every callback is typed for the payload received by the handler.
+// A callback set only for another payload would silently never be called.
+func (c *Config) validateFor(payload string) error {
+	handled := map[string]bool{}
+	for _, name := range c.callbacksFor(payload) {
+		handled[name] = true
+	}
+
+	for _, other := range incomingPayloads {
+		if other == payload {
+			continue
+		}
+		for _, name := range c.callbacksFor(other) {
+			if !handled[name] {
+				return fmt.Errorf("option %s is typed for %s events but the handler receives %s events", name, other, payload)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package moesifawslambda
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestNewConfigTranslatesLegacyOptions(t *testing.T) {
+	identifyUser := func(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse) string {
+		return "my_user"
+	}
+	config, err := NewConfig(map[string]interface{}{
+		"Application_Id": "Your Moesif Application Id",
+		"Api_Version":    "1.0.0",
+		"Log_Body":       false,
+		"Identify_User":  identifyUser,
+	}, WithDebug(true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if config.ApiVersion != "1.0.0" || config.LogBody || !config.LogBodyOutgoing || !config.Debug {
+		t.Errorf("got %+v, want the map and options applied over the defaults", config)
+	}
+	if config.APIGatewayV2HTTP.IdentifyUser == nil || config.APIGatewayProxy.IdentifyUser != nil {
+		t.Errorf("Identify_User was not routed to the v2 callbacks")
+	}
+}
+
+func TestNewConfigRejectsInvalidOptions(t *testing.T) {
+	var testcases = []map[string]interface{}{
+		{"Debug": "true"},
+		{"Identify_User": func(request events.APIGatewayProxyRequest) string { return "" }},
+		{"Error_Policy": "ignore"},
+	}
+
+	for _, tt := range testcases {
+		if _, err := NewConfig(tt); err == nil {
+			t.Errorf("got no error for %v", tt)
+		}
+	}
+}
+
+func TestMoesifLoggerRejectsCallbackForAnotherPayload(t *testing.T) {
+	identifyUser := func(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse) string {
+		return "my_user"
+	}
+
+	_, err := NewMoesifLogger(HandleLambdaEventV2HTTP, WithAPIGatewayProxyCallbacks(APIGatewayProxyCallbacks{IdentifyUser: identifyUser}))
+	if err == nil || !strings.Contains(err.Error(), "Identify_User") {
+		t.Errorf("got %v, want an error about Identify_User", err)
+	}
+
+	if _, err := NewMoesifLogger(HandleLambdaEvent, WithAPIGatewayProxyCallbacks(APIGatewayProxyCallbacks{IdentifyUser: identifyUser})); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
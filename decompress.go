@@ -0,0 +1,106 @@
+package moesifawslambda
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Bodies are never decompressed beyond this size in bytes, whatever their size limit
+const defaultMaxDecompressedBodySize = 10 * 1024 * 1024
+
+// The content codings of a Content-Encoding header, in the order they were applied
+func contentCodings(contentEncoding string) []string {
+	var codings []string
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// Reader decompressing a body compressed with the content coding
+func decompressor(coding string, body io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but some servers send raw deflate data
+		buffered := bufio.NewReader(body)
+		if header, err := buffered.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	case "br":
+		return brotli.NewReader(body), nil
+	}
+	return nil, fmt.Errorf("unsupported content coding %q", coding)
+}
+
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+// Decompress a body according to its Content-Encoding up to Max_Decompressed_Body_Size bytes, keeping at most
+// limit bytes of it (0 for no limit) while counting its whole decompressed size.
+// Returns nil when the body is not compressed or can't be decompressed.
+// The body may be cut short by a size limit, the part of it that could be decompressed is kept
+func decompressBody(body []byte, contentEncoding string, limit int) *bodyCapture {
+	codings := contentCodings(contentEncoding)
+	if !moesifConfig.DecompressBodies || len(codings) == 0 {
+		return nil
+	}
+
+	var reader io.Reader = bytes.NewReader(body)
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		if reader, err = decompressor(codings[i], reader); err != nil {
+			if debug {
+				log.Printf("Error while decompressing the body: %s.\n", err.Error())
+			}
+			return nil
+		}
+	}
+
+	capture := &bodyCapture{limit: limit}
+	_, err := io.Copy(capture, io.LimitReader(reader, int64(moesifConfig.MaxDecompressedBodySize)))
+	if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && capture.size > 0) {
+		if debug {
+			log.Printf("Error while decompressing the body: %s.\n", err.Error())
+		}
+		return nil
+	}
+	// The body is cut short when there is more to decompress beyond the cap
+	if capture.size >= int64(moesifConfig.MaxDecompressedBodySize) {
+		if n, _ := reader.Read(make([]byte, 1)); n > 0 {
+			capture.capped = true
+		}
+	}
+	return capture
+}
+
+// The captured body of an outgoing call, decompressed according to its Content-Encoding.
+// Returns the original size of a truncated body
+func capturedBody(capture *bodyCapture, contentEncoding string) ([]byte, bodyTruncation) {
+	body, _ := capture.body()
+	original := capture.truncation()
+	if decompressed := decompressBody(body, contentEncoding, moesifConfig.MaxBodySizeOutgoing); decompressed != nil {
+		body, _ = decompressed.body()
+		// The size of the compressed body is kept when it was truncated before it was decompressed
+		if original.size < 0 {
+			original = decompressed.truncation()
+		}
+	}
+	return body, original
+}
@@ -0,0 +1,215 @@
+package moesifawslambda
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	b64 "encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func compress(t *testing.T, coding string, body string) []byte {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch coding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "deflate":
+		writer = zlib.NewWriter(&buffer)
+	case "raw deflate":
+		writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+	case "br":
+		writer = brotli.NewWriter(&buffer)
+	default:
+		t.Fatalf("unknown coding %q", coding)
+	}
+	writer.Write([]byte(body))
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	resetClient()
+	config, err := NewConfig(MoesifOptions(), WithMaxDecompressedBodySize(1024))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moesifClient(config)
+
+	body := `{"message": "hello"}`
+	tests := []struct {
+		compressed      []byte
+		contentEncoding string
+		limit           int
+		expected        string
+		size            int64
+		capped          bool
+	}{
+		{compress(t, "gzip", body), "gzip", 0, body, int64(len(body)), false},
+		{compress(t, "gzip", body), "X-GZIP", 0, body, int64(len(body)), false},
+		{compress(t, "deflate", body), "deflate", 0, body, int64(len(body)), false},
+		{compress(t, "raw deflate", body), "deflate", 0, body, int64(len(body)), false},
+		{compress(t, "br", body), "br", 0, body, int64(len(body)), false},
+		// The codings are listed in the order they were applied
+		{compress(t, "br", string(compress(t, "gzip", body))), "gzip, br", 0, body, int64(len(body)), false},
+		{compress(t, "gzip", body), "gzip", 6, `{"mess`, int64(len(body)), false},
+		// Decompression stops at Max_Decompressed_Body_Size, the size is then a lower bound
+		{compress(t, "gzip", strings.Repeat("a", 4096)), "gzip", 0, strings.Repeat("a", 1024), 1024, true},
+		{compress(t, "gzip", strings.Repeat("a", 1024)), "gzip", 0, strings.Repeat("a", 1024), 1024, false},
+	}
+
+	for _, test := range tests {
+		capture := decompressBody(test.compressed, test.contentEncoding, test.limit)
+		if capture == nil {
+			t.Errorf("got no body for %q, want %q", test.contentEncoding, test.expected)
+			continue
+		}
+		if decompressed, _ := capture.body(); string(decompressed) != test.expected || capture.size != test.size || capture.capped != test.capped {
+			t.Errorf("got %q of %d bytes, capped %v, for %q, want %q of %d bytes, capped %v", decompressed, capture.size, capture.capped, test.contentEncoding, test.expected, test.size, test.capped)
+		}
+	}
+
+	for contentEncoding, compressed := range map[string][]byte{
+		"":         []byte(body),
+		"identity": []byte(body),
+		"zstd":     compress(t, "gzip", body),
+		"gzip":     []byte(body),
+	} {
+		if capture := decompressBody(compressed, contentEncoding, 0); capture != nil {
+			t.Errorf("got a body for %q, want the body left as is", contentEncoding)
+		}
+	}
+}
+
+func TestMoesifLoggerDecompressesBodies(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{
+			StatusCode:      200,
+			Headers:         map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip"},
+			Body:            b64.StdEncoding.EncodeToString(compress(t, "gzip", `{"id": 1}`)),
+			IsBase64Encoded: true,
+		}, nil
+	}, MoesifOptions()).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	if _, err := handler(context.Background(), generateProxyReq(nil, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if body := *sent[0].Response.Body.(*interface{}); !reflect.DeepEqual(body, map[string]interface{}{"id": float64(1)}) {
+		t.Errorf("got response body %v, want it decompressed", body)
+	}
+}
+
+func TestCappedDecompressionsAreRecorded(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{
+			StatusCode:      200,
+			Headers:         map[string]string{"Content-Type": "text/plain", "Content-Encoding": "gzip"},
+			Body:            b64.StdEncoding.EncodeToString(compress(t, "gzip", strings.Repeat("a", 4096))),
+			IsBase64Encoded: true,
+		}, nil
+	}, MoesifOptions(), WithMaxDecompressedBodySize(1024), WithMaxBodySize(16)).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	if _, err := handler(context.Background(), generateProxyReq(nil, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	// The size decompressed before the cap is only a lower bound of the original size
+	expected := map[string]interface{}{"response": int64(1024), "decompression_capped": map[string]interface{}{"response": true}}
+	if sizes := (*sent[0].Metadata.(*map[string]interface{}))[truncatedBodyMetadataKey]; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("got truncated body sizes %v, want %v", sizes, expected)
+	}
+}
+
+func TestDecompressedBodiesAreTruncatedToTheirSizeLimit(t *testing.T) {
+	resetClient()
+	payload := strings.Repeat("a", 512)
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{
+			StatusCode:      200,
+			Headers:         map[string]string{"Content-Type": "text/plain", "Content-Encoding": "gzip"},
+			Body:            b64.StdEncoding.EncodeToString(compress(t, "gzip", payload)),
+			IsBase64Encoded: true,
+		}, nil
+	}, MoesifOptions(), WithMaxDecompressedBodySize(1024), WithMaxBodySize(16)).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	if _, err := handler(context.Background(), generateProxyReq(nil, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if body := *sent[0].Response.Body.(*interface{}); body != payload[:16] {
+		t.Errorf("got response body %v, want it truncated to Max_Body_Size", body)
+	}
+	// The whole body is decompressed below the cap, its decompressed size is exact
+	expected := map[string]interface{}{"response": int64(len(payload))}
+	if sizes := (*sent[0].Metadata.(*map[string]interface{}))[truncatedBodyMetadataKey]; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("got truncated body sizes %v, want %v", sizes, expected)
+	}
+}
+
+func TestOutgoingResponseBodiesAreDecompressed(t *testing.T) {
+	compressed := compress(t, "br", `{"id": 1}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "br")
+		w.Write(compressed)
+	}))
+	defer server.Close()
+
+	resetClient()
+	config, err := NewConfig(MoesifOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moesifClient(config)
+	logBodyOutgoing = true
+	defer func() { logBodyOutgoing = false }()
+
+	client := &http.Client{Transport: &Transport{Transport: http.DefaultTransport}}
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request.Header.Set("Accept-Encoding", "br")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The application receives the compressed body
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if !bytes.Equal(body, compressed) {
+		t.Errorf("got body %q, want the compressed body", body)
+	}
+	Flush(context.Background())
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if body := sent[0].Response.Body; !reflect.DeepEqual(body, map[string]interface{}{"id": float64(1)}) {
+		t.Errorf("got response body %v, want it decompressed", body)
+	}
+}
@@ -0,0 +1,190 @@
+package moesifawslambda
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Error policies applied when data could not be delivered to Moesif
+const (
+	// Drop the failed payload and report the error
+	ErrorPolicyDrop = "drop"
+	// Retry the delivery a few times before dropping it
+	ErrorPolicyRetry = "retry"
+	// Keep the failed payload in memory and retry it on the next delivery
+	ErrorPolicySpool = "spool"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 100 * time.Millisecond
+	defaultSpoolSize     = 100
+)
+
+// DeliveryError is passed to the On_Error callback when data could not be delivered to Moesif
+type DeliveryError struct {
+	Operation string
+	Err       error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("error while %s: %s", e.Operation, e.Err.Error())
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// A delivery that failed and is waiting in the spool
+type pendingDelivery struct {
+	operation string
+	send      func() error
+}
+
+var (
+	errorPolicy   string
+	retryAttempts int
+	retryBackoff  time.Duration
+	spoolSize     int
+	onError       func(error)
+	spool         []pendingDelivery
+	spoolMutex    sync.Mutex
+)
+
+// Initialize the error handling options
+func configureErrorHandling(config *Config) {
+	errorPolicy = config.ErrorPolicy
+	retryAttempts = config.RetryAttempts
+	retryBackoff = defaultRetryBackoff
+	spoolSize = config.SpoolSize
+	onError = config.OnError
+}
+
+// Deliver data to Moesif, applying the configured error policy on failure.
+// It never panics and never terminates the process.
+func deliver(operation string, send func() error) {
+	if errorPolicy == ErrorPolicySpool {
+		drainSpool()
+	}
+
+	err := attemptDelivery(send)
+	if err == nil {
+		return
+	}
+
+	switch errorPolicy {
+	case ErrorPolicyRetry:
+		err = retryDelivery(operation, send, err)
+	case ErrorPolicySpool:
+		spoolDelivery(pendingDelivery{operation: operation, send: send})
+	}
+
+	if err != nil {
+		reportError(&DeliveryError{Operation: operation, Err: err})
+	}
+}
+
+// Deliver data to Moesif while the caller waits, such as the updates of users and companies.
+// With the retry policy, a failed delivery is retried in the background with the batches of the queue,
+// so that the caller is never delayed by the backoff; the retries are awaited by the end-of-invocation flush
+func deliverNow(operation string, send func() error) {
+	if errorPolicy != ErrorPolicyRetry || queue == nil {
+		deliver(operation, send)
+		return
+	}
+
+	err := attemptDelivery(send)
+	if err == nil {
+		return
+	}
+	queue.background(func() {
+		if err := retryDelivery(operation, send, err); err != nil {
+			reportError(&DeliveryError{Operation: operation, Err: err})
+		}
+	})
+}
+
+// Retry a failed delivery with a growing backoff, returns the error of the last attempt
+func retryDelivery(operation string, send func() error, err error) error {
+	for attempt := 1; attempt <= retryAttempts && err != nil; attempt++ {
+		if debug {
+			log.Printf("Retrying %s, attempt %d", operation, attempt)
+		}
+		time.Sleep(retryBackoff * time.Duration(attempt))
+		err = attemptDelivery(send)
+	}
+	return err
+}
+
+// Call send, converting a panic raised by the Moesif API client into an error
+func attemptDelivery(send func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return send()
+}
+
+// Keep the failed delivery for a later attempt, evicting the oldest one when the spool is full
+func spoolDelivery(delivery pendingDelivery) {
+	spoolMutex.Lock()
+	defer spoolMutex.Unlock()
+
+	if spoolSize <= 0 {
+		return
+	}
+	if len(spool) >= spoolSize {
+		evicted := spool[0]
+		spool = spool[1:]
+		if debug {
+			log.Printf("Spool is full, dropping %s", evicted.operation)
+		}
+	}
+	spool = append(spool, delivery)
+}
+
+// Retry every spooled delivery once, keeping the ones that fail again
+func drainSpool() {
+	spoolMutex.Lock()
+	pending := spool
+	spool = nil
+	spoolMutex.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	if debug {
+		log.Printf("Retrying %d spooled deliveries", len(pending))
+	}
+
+	var failed []pendingDelivery
+	for _, delivery := range pending {
+		if err := attemptDelivery(delivery.send); err != nil {
+			failed = append(failed, delivery)
+		}
+	}
+
+	spoolMutex.Lock()
+	spool = append(failed, spool...)
+	if spoolSize > 0 && len(spool) > spoolSize {
+		spool = spool[len(spool)-spoolSize:]
+	}
+	spoolMutex.Unlock()
+}
+
+// Report the error to the On_Error callback, or log it
+func reportError(err error) {
+	if onError != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("On_Error callback panicked: %v", r)
+			}
+		}()
+		onError(err)
+		return
+	}
+	log.Printf("%s.\n", err.Error())
+}
@@ -0,0 +1,129 @@
+package moesifawslambda
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverRecoversPanicAndReportsError(t *testing.T) {
+	var reported []error
+	configureErrorHandling(&Config{
+		ErrorPolicy: ErrorPolicyDrop,
+		OnError:     func(err error) { reported = append(reported, err) },
+	})
+
+	deliver("sending event to Moesif", func() error {
+		var header map[string][]string
+		header["boom"] = nil
+		return nil
+	})
+
+	if len(reported) != 1 {
+		t.Fatalf("got %d reported errors, want 1", len(reported))
+	}
+	var deliveryErr *DeliveryError
+	if !errors.As(reported[0], &deliveryErr) || deliveryErr.Operation != "sending event to Moesif" {
+		t.Errorf("got %v, want a DeliveryError for the send operation", reported[0])
+	}
+}
+
+func TestDeliverRetryPolicy(t *testing.T) {
+	var reported []error
+	configureErrorHandling(&Config{
+		ErrorPolicy:   ErrorPolicyRetry,
+		RetryAttempts: 2,
+		OnError:       func(err error) { reported = append(reported, err) },
+	})
+	retryBackoff = 0
+
+	calls := 0
+	deliver("sending event to Moesif", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+	if len(reported) != 0 {
+		t.Errorf("got %v, want no reported errors", reported)
+	}
+}
+
+func TestDeliverNowRetriesInTheBackground(t *testing.T) {
+	var reported []error
+	configureErrorHandling(&Config{
+		ErrorPolicy:   ErrorPolicyRetry,
+		RetryAttempts: 2,
+		OnError:       func(err error) { reported = append(reported, err) },
+	})
+	defer func(q *eventQueue) { queue = q }(queue)
+	queue = newEventQueue(defaultBatchSize, defaultMaxQueueSize, sendEventsBatch)
+
+	var calls int32
+	start := time.Now()
+	deliverNow("updating user", func() error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	// The caller doesn't wait for the backoff of the retries
+	if elapsed := time.Since(start); elapsed >= retryBackoff {
+		t.Errorf("got the caller delayed by %v, want no backoff", elapsed)
+	}
+	Flush(context.Background())
+	if calls := atomic.LoadInt32(&calls); calls != 3 {
+		t.Errorf("got %d calls once flushed, want 3", calls)
+	}
+	if len(reported) != 0 {
+		t.Errorf("got %v, want no reported errors", reported)
+	}
+}
+
+func TestDeliverSpoolPolicy(t *testing.T) {
+	var reported []error
+	configureErrorHandling(&Config{
+		ErrorPolicy: ErrorPolicySpool,
+		SpoolSize:   1,
+		OnError:     func(err error) { reported = append(reported, err) },
+	})
+	spool = nil
+
+	var sent []string
+	failing := true
+	send := func(name string) func() error {
+		return func() error {
+			if failing {
+				return errors.New("unavailable")
+			}
+			sent = append(sent, name)
+			return nil
+		}
+	}
+
+	deliver("first", send("first"))
+	deliver("second", send("second"))
+	if len(spool) != 1 || spool[0].operation != "second" {
+		t.Fatalf("got %v, want only the newest delivery spooled", spool)
+	}
+
+	failing = false
+	deliver("third", send("third"))
+	if len(spool) != 0 {
+		t.Errorf("got %d spooled deliveries, want 0", len(spool))
+	}
+	if len(sent) != 2 || sent[0] != "second" || sent[1] != "third" {
+		t.Errorf("got %v, want [second third]", sent)
+	}
+	if len(reported) != 2 {
+		t.Errorf("got %d reported errors, want 2", len(reported))
+	}
+}
@@ -0,0 +1,179 @@
+package moesifawslambda
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+const (
+	defaultBatchSize    = 25
+	defaultMaxQueueSize = 1000
+	// The end-of-invocation flush is kept short, what is left is sent during the next invocations
+	defaultFlushTimeout = time.Second
+	// Time kept for the handler to return after the flush when the invocation has a deadline
+	flushDeadlineMargin = 50 * time.Millisecond
+)
+
+// eventQueue buffers incoming and outgoing events and ships them in batches
+type eventQueue struct {
+	mutex     sync.Mutex
+	events    []*models.EventModel
+	batchSize int
+	maxSize   int
+	inflight  []chan struct{}
+	sendBatch func([]*models.EventModel) error
+}
+
+// The queue shared by every event of the execution environment
+var queue *eventQueue
+
+func newEventQueue(batchSize int, maxSize int, sendBatch func([]*models.EventModel) error) *eventQueue {
+	return &eventQueue{
+		batchSize: batchSize,
+		maxSize:   maxSize,
+		sendBatch: sendBatch,
+	}
+}
+
+// Send a batch of events with the Moesif API client
+func sendEventsBatch(batch []*models.EventModel) error {
+	headers, err := apiClient.CreateEventsBatch(batch)
+	if err == nil {
+		// Moesif reports the etag of the latest application config
+		remoteConfig.notify(configEtag(headers))
+		governanceRules.notify(headers.Get("X-Moesif-Rules-Tag"))
+	}
+	return err
+}
+
+// Add the event to the queue, shipping a batch in the background once enough events are buffered
+func (q *eventQueue) enqueue(event *models.EventModel) {
+	q.mutex.Lock()
+	dropped := q.maxSize > 0 && len(q.events) >= q.maxSize
+	if dropped {
+		q.events = q.events[1:]
+	}
+	q.events = append(q.events, event)
+
+	if len(q.events) >= q.batchSize {
+		batch := q.events
+		q.events = nil
+		q.ship(batch)
+	}
+	q.mutex.Unlock()
+
+	if dropped {
+		reportError(&DeliveryError{Operation: "queueing event", Err: fmt.Errorf("queue is full, dropped the oldest event")})
+	}
+}
+
+// Ship the batch in the background. The caller must hold the mutex.
+func (q *eventQueue) ship(batch []*models.EventModel) {
+	q.start(func() {
+		deliver("sending events to Moesif", func() error {
+			err := q.sendBatch(batch)
+			if err == nil && debug {
+				log.Printf("Successfully sent %d events to Moesif", len(batch))
+			}
+			return err
+		})
+	})
+}
+
+// Run a delivery in the background, awaited by the flush like the batches
+func (q *eventQueue) background(delivery func()) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.start(delivery)
+}
+
+// Run the delivery in flight. The caller must hold the mutex.
+func (q *eventQueue) start(delivery func()) {
+	done := make(chan struct{})
+	q.inflight = append(q.inflight, done)
+	go func() {
+		defer q.finish(done)
+		delivery()
+	}()
+}
+
+// Forget the batch once it is delivered
+func (q *eventQueue) finish(done chan struct{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i, inflight := range q.inflight {
+		if inflight == done {
+			q.inflight = append(q.inflight[:i], q.inflight[i+1:]...)
+			break
+		}
+	}
+	close(done)
+}
+
+// Ship every buffered event and wait for the batches in flight until the context is done
+func (q *eventQueue) flush(ctx context.Context) {
+	q.mutex.Lock()
+	pending := q.events
+	q.events = nil
+	for len(pending) > 0 {
+		size := q.batchSize
+		if size <= 0 || size > len(pending) {
+			size = len(pending)
+		}
+		q.ship(pending[:size])
+		pending = pending[size:]
+	}
+	inflight := append([]chan struct{}(nil), q.inflight...)
+	q.mutex.Unlock()
+
+	for _, done := range inflight {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			if debug {
+				log.Printf("Flush timed out, remaining events are sent when the execution environment resumes")
+			}
+			return
+		}
+	}
+}
+
+// Bound the end-of-invocation flush by the timeout, and by the invocation deadline when it comes first
+func flushContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	limit := time.Now().Add(timeout)
+	if deadline, found := ctx.Deadline(); found && deadline.Add(-flushDeadlineMargin).Before(limit) {
+		limit = deadline.Add(-flushDeadlineMargin)
+	}
+	return context.WithDeadline(context.Background(), limit)
+}
+
+// Flush sends every buffered event to Moesif, waiting until ctx is done.
+// MoesifLogger flushes at the end of every invocation; call Flush when capturing
+// outgoing requests outside of a wrapped handler.
+func Flush(ctx context.Context) {
+	// The outgoing calls whose response bodies were not read to the end are sent with the part that was read,
+	// their events are queued once their response bodies are captured
+	finishTeeBodies()
+	waitTeeBodies(ctx)
+	if queue != nil {
+		queue.flush(ctx)
+	}
+}
+
+// Flush at the end of the invocation for at most Flush_Timeout, bounded by the remaining time of the invocation.
+// The batches still in flight resume with the execution environment.
+func flushInvocation(ctx context.Context) {
+	flushCtx, cancel := flushContext(ctx, moesifConfig.FlushTimeout)
+	defer cancel()
+	Flush(flushCtx)
+
+	// The extension sends the events once the response has been returned
+	if moesifConfig.ExtensionMode {
+		notifyExtension(ctx)
+	}
+}
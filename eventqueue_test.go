@@ -0,0 +1,108 @@
+package moesifawslambda
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+func TestEventQueueShipsFullBatchesAndFlushesTheRest(t *testing.T) {
+	recorder := &recordingAPI{}
+	q := newEventQueue(2, 0, func(batch []*models.EventModel) error {
+		_, err := recorder.CreateEventsBatch(batch)
+		return err
+	})
+
+	for i := 0; i < 3; i++ {
+		q.enqueue(&models.EventModel{})
+	}
+	q.flush(context.Background())
+
+	// Batches are shipped concurrently and may arrive in any order
+	if len(recorder.batches) != 2 || len(recorder.events()) != 3 {
+		t.Errorf("got batches %v, want 3 events in 2 batches", recorder.batches)
+	}
+}
+
+func TestEventQueueFlushIsBoundedByContext(t *testing.T) {
+	release := make(chan struct{})
+	q := newEventQueue(10, 0, func(batch []*models.EventModel) error {
+		<-release
+		return nil
+	})
+	q.enqueue(&models.EventModel{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	q.flush(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("flush took %v, want it bounded by the context", elapsed)
+	}
+
+	close(release)
+	q.flush(context.Background())
+}
+
+func TestEventQueueDropsOldestWhenFull(t *testing.T) {
+	var reported []error
+	configureErrorHandling(&Config{ErrorPolicy: ErrorPolicyDrop, OnError: func(err error) { reported = append(reported, err) }})
+
+	q := newEventQueue(10, 2, func(batch []*models.EventModel) error { return nil })
+	first, second, third := &models.EventModel{}, &models.EventModel{}, &models.EventModel{}
+	q.enqueue(first)
+	q.enqueue(second)
+	q.enqueue(third)
+
+	if len(q.events) != 2 || q.events[0] != second || q.events[1] != third {
+		t.Errorf("got %v, want the two newest events", q.events)
+	}
+	if len(reported) != 1 {
+		t.Errorf("got %d reported errors, want 1", len(reported))
+	}
+}
+
+func TestFlushInvocationUsesDeadline(t *testing.T) {
+	deadline := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	flushCtx, flushCancel := flushContext(ctx, time.Minute)
+	defer flushCancel()
+
+	got, found := flushCtx.Deadline()
+	if !found || !got.Equal(deadline.Add(-flushDeadlineMargin)) {
+		t.Errorf("got deadline %v, want %v", got, deadline.Add(-flushDeadlineMargin))
+	}
+}
+
+func TestFlushInvocationUsesTimeout(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Minute))
+	defer cancel()
+
+	before := time.Now()
+	flushCtx, flushCancel := flushContext(ctx, 100*time.Millisecond)
+	defer flushCancel()
+
+	got, found := flushCtx.Deadline()
+	if !found || got.Before(before.Add(100*time.Millisecond)) || got.After(time.Now().Add(100*time.Millisecond)) {
+		t.Errorf("got deadline %v, want 100ms from now", got)
+	}
+}
+
+func TestMoesifLoggerFlushesBeforeReturning(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions()).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	if _, err := handler(context.Background(), generateProxyReq([]byte(`{"foo": "bar"}`), false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sent := testAPI.events(); len(sent) != 1 {
+		t.Errorf("got %d events sent, want 1", len(sent))
+	}
+}
@@ -0,0 +1,97 @@
+package extension
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	extensionNameHeader       = "Lambda-Extension-Name"
+	extensionIdentifierHeader = "Lambda-Extension-Identifier"
+	extensionAPIVersion       = "2020-01-01"
+)
+
+// Lifecycle events sent by the Extensions API
+const (
+	eventInvoke   = "INVOKE"
+	eventShutdown = "SHUTDOWN"
+)
+
+// lifecycleEvent is returned by the event/next endpoint of the Extensions API
+type lifecycleEvent struct {
+	EventType      string `json:"eventType"`
+	DeadlineMs     int64  `json:"deadlineMs"`
+	RequestID      string `json:"requestId"`
+	ShutdownReason string `json:"shutdownReason"`
+}
+
+// apiClient talks to the Lambda Extensions API
+type apiClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newAPIClient(runtimeAPI string) *apiClient {
+	return &apiClient{
+		baseURL: "http://" + runtimeAPI + "/" + extensionAPIVersion + "/extension/",
+		// The event/next call blocks until the next invocation, it must never time out
+		httpClient: &http.Client{},
+	}
+}
+
+// Register the extension for the INVOKE and SHUTDOWN events, returning its identifier
+func (c *apiClient) register(ctx context.Context, name string) (string, error) {
+	body, err := json.Marshal(map[string][]string{"events": {eventInvoke, eventShutdown}})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"register", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(extensionNameHeader, name)
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to register extension: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to register extension, got response status: %d", resp.StatusCode)
+	}
+	return resp.Header.Get(extensionIdentifierHeader), nil
+}
+
+// Wait for the next lifecycle event
+func (c *apiClient) next(ctx context.Context, id string) (*lifecycleEvent, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"event/next", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(extensionIdentifierHeader, id)
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extension event: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("failed to get extension event, got response status: %d", resp.StatusCode)
+	}
+
+	event := &lifecycleEvent{}
+	if err := json.NewDecoder(resp.Body).Decode(event); err != nil {
+		return nil, fmt.Errorf("failed to decode extension event: %s", err.Error())
+	}
+	return event, nil
+}
@@ -0,0 +1,146 @@
+// Package extension implements a Lambda Extension shipping the events captured by MoesifLogger
+// in extension mode. The wrapped handler posts its events to the extension over a local HTTP
+// endpoint, and the extension sends them to Moesif once the function has returned its response.
+package extension
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	moesifapi "github.com/moesif/moesifapi-go"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+const (
+	// Address the extension listens on for the events of the wrapped handler
+	DefaultAddress = "127.0.0.1:8765"
+	// Path the wrapped handler posts its events to, as a JSON array of EventModel
+	EventsPath = "/events"
+	// Header carrying the AWS request ID of the invocation
+	RequestIdHeader = "Lambda-Runtime-Aws-Request-Id"
+	// Header set to "true" once the wrapped handler is done with the invocation
+	InvocationDoneHeader = "X-Moesif-Invocation-Done"
+)
+
+const (
+	batchSize = 25
+	// Time kept to ship the events before the invocation deadline
+	deadlineMargin = 100 * time.Millisecond
+)
+
+// Options configures the extension
+type Options struct {
+	// Name registered with the Extensions API, defaults to the executable name
+	Name string
+	// Host and port of the Extensions API, defaults to AWS_LAMBDA_RUNTIME_API
+	RuntimeAPI string
+	// Listener receiving the events of the wrapped handler, defaults to listening on DefaultAddress
+	Listener net.Listener
+	// Ship sends a batch of events, defaults to the Moesif batch API
+	Ship  func([]*models.EventModel) error
+	Debug bool
+}
+
+// Run registers the extension and ships the events of every invocation until the SHUTDOWN event
+// or until ctx is done.
+func Run(ctx context.Context, options Options) error {
+	if options.Name == "" {
+		options.Name = filepath.Base(os.Args[0])
+	}
+	if options.RuntimeAPI == "" {
+		options.RuntimeAPI = os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	}
+	if options.Ship == nil {
+		options.Ship = moesifShipper(os.Getenv("MOESIF_APPLICATION_ID"))
+	}
+
+	client := newAPIClient(options.RuntimeAPI)
+	id, err := client.register(ctx, options.Name)
+	if err != nil {
+		return err
+	}
+
+	listener := options.Listener
+	if listener == nil {
+		listener, err = net.Listen("tcp", DefaultAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %s", DefaultAddress, err.Error())
+		}
+	}
+
+	receiver := newReceiver()
+	server := &http.Server{Handler: receiver}
+	go server.Serve(listener)
+	defer server.Close()
+
+	for {
+		event, err := client.next(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				ship(options, receiver.drain())
+				return nil
+			}
+			return err
+		}
+
+		switch event.EventType {
+		case eventInvoke:
+			// Wait for the wrapped handler; the response has already been returned to the caller by then.
+			// A handler panicking without Capture_Errors never posts that it is done, the deadline is the fallback
+			var deadline time.Time
+			if event.DeadlineMs > 0 {
+				deadline = time.Unix(0, event.DeadlineMs*int64(time.Millisecond)).Add(-deadlineMargin)
+			}
+			receiver.wait(ctx, event.RequestID, deadline)
+			ship(options, receiver.drain())
+		case eventShutdown:
+			if options.Debug {
+				log.Printf("Shutting down the Moesif extension: %s", event.ShutdownReason)
+			}
+			ship(options, receiver.drain())
+			return nil
+		}
+	}
+}
+
+// Ship the events in batches, logging the failures
+func ship(options Options, events []*models.EventModel) {
+	for len(events) > 0 {
+		size := batchSize
+		if size > len(events) {
+			size = len(events)
+		}
+		if err := safeShip(options.Ship, events[:size]); err != nil {
+			log.Printf("Error while sending events to Moesif: %s.\n", err.Error())
+		} else if options.Debug {
+			log.Printf("Successfully sent %d events to Moesif", size)
+		}
+		events = events[size:]
+	}
+}
+
+// Call ship, converting a panic raised by the Moesif API client into an error
+func safeShip(ship func([]*models.EventModel) error, batch []*models.EventModel) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprint(r))
+		}
+	}()
+	return ship(batch)
+}
+
+// Send the events with the Moesif batch API
+func moesifShipper(applicationId string) func([]*models.EventModel) error {
+	api := moesifapi.NewAPI(applicationId)
+	return func(batch []*models.EventModel) error {
+		_, err := api.CreateEventsBatch(batch)
+		return err
+	}
+}
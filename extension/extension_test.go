@@ -0,0 +1,205 @@
+package extension_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	moesifawslambda "github.com/moesif/moesif-aws-lambda-go"
+	"github.com/moesif/moesif-aws-lambda-go/extension"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// standInAPI is a local stand-in of the Lambda Extensions API
+type standInAPI struct {
+	server     *httptest.Server
+	registered string
+	events     chan map[string]interface{}
+}
+
+func newStandInAPI(t *testing.T) *standInAPI {
+	api := &standInAPI{events: make(chan map[string]interface{}, 10)}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2020-01-01/extension/register":
+			api.registered = r.Header.Get("Lambda-Extension-Name")
+			w.Header().Set("Lambda-Extension-Identifier", "extension-id")
+			w.WriteHeader(http.StatusOK)
+		case "/2020-01-01/extension/event/next":
+			if r.Header.Get("Lambda-Extension-Identifier") != "extension-id" {
+				t.Errorf("event/next called without the extension identifier")
+			}
+			json.NewEncoder(w).Encode(<-api.events)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return api
+}
+
+func (api *standInAPI) invoke(requestId string) {
+	api.invokeUntil(requestId, time.Now().Add(5*time.Second))
+}
+
+func (api *standInAPI) invokeUntil(requestId string, deadline time.Time) {
+	api.events <- map[string]interface{}{
+		"eventType":  "INVOKE",
+		"requestId":  requestId,
+		"deadlineMs": deadline.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func (api *standInAPI) shutdown() {
+	api.events <- map[string]interface{}{"eventType": "SHUTDOWN", "shutdownReason": "spindown"}
+}
+
+// shipper records the batches shipped by the extension
+type shipper struct {
+	mutex   sync.Mutex
+	batches [][]*models.EventModel
+}
+
+func (s *shipper) ship(batch []*models.EventModel) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *shipper) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for _, batch := range s.batches {
+		count += len(batch)
+	}
+	return count
+}
+
+func startExtension(t *testing.T, api *standInAPI, shipped *shipper) (string, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- extension.Run(context.Background(), extension.Options{
+			Name:       "moesif-lambda-extension",
+			RuntimeAPI: strings.TrimPrefix(api.server.URL, "http://"),
+			Listener:   listener,
+			Ship:       shipped.ship,
+		})
+	}()
+	return listener.Addr().String(), done
+}
+
+func waitForRun(t *testing.T, done chan error) {
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the extension did not shut down")
+	}
+}
+
+func TestRunShipsEventsOnceTheInvocationIsDone(t *testing.T) {
+	api := newStandInAPI(t)
+	defer api.server.Close()
+	shipped := &shipper{}
+	address, done := startExtension(t, api, shipped)
+
+	api.invoke("request-1")
+
+	// Events posted before the invocation is done are buffered
+	post(t, address, "", `[{"request": {"uri": "https://example.com/a", "verb": "GET"}}]`)
+	post(t, address, "request-1", `[{"request": {"uri": "https://example.com/b", "verb": "GET"}}]`)
+
+	api.shutdown()
+	waitForRun(t, done)
+
+	if api.registered != "moesif-lambda-extension" {
+		t.Errorf("got registered name %q, want moesif-lambda-extension", api.registered)
+	}
+	if shipped.count() != 2 {
+		t.Errorf("got %d shipped events, want 2", shipped.count())
+	}
+}
+
+func TestRunShipsTheEventsOfAnInvocationNeverDoneAtItsDeadline(t *testing.T) {
+	api := newStandInAPI(t)
+	defer api.server.Close()
+	shipped := &shipper{}
+	address, done := startExtension(t, api, shipped)
+
+	// The handler panics without Capture_Errors after posting an event, it never posts that it is done
+	start := time.Now()
+	api.invokeUntil("request-1", start.Add(300*time.Millisecond))
+	post(t, address, "", `[{"request": {"uri": "https://example.com/a", "verb": "GET"}}]`)
+
+	// Its event is shipped at the deadline of the invocation, without waiting for the next one
+	for shipped.count() != 1 {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("got %d shipped events after the deadline, want 1", shipped.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("got the events shipped after %v, want the extension to wait for the deadline", elapsed)
+	}
+
+	api.shutdown()
+	waitForRun(t, done)
+}
+
+func TestRunWithMoesifLoggerInExtensionMode(t *testing.T) {
+	api := newStandInAPI(t)
+	defer api.server.Close()
+	shipped := &shipper{}
+	address, done := startExtension(t, api, shipped)
+
+	api.invoke("request-1")
+
+	handler := moesifawslambda.MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	}, nil, moesifawslambda.WithExtensionMode(true), moesifawslambda.WithExtensionAddress(address), moesifawslambda.WithSampling(false))
+
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+	_, err := handler.(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))(ctx, events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	api.shutdown()
+	waitForRun(t, done)
+
+	if shipped.count() != 1 {
+		t.Errorf("got %d shipped events, want 1", shipped.count())
+	}
+}
+
+func post(t *testing.T, address string, doneRequestId string, body string) {
+	req, _ := http.NewRequest(http.MethodPost, "http://"+address+extension.EventsPath, strings.NewReader(body))
+	if doneRequestId != "" {
+		req.Header.Set(extension.RequestIdHeader, doneRequestId)
+		req.Header.Set(extension.InvocationDoneHeader, "true")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", resp.StatusCode)
+	}
+}
@@ -0,0 +1,108 @@
+package extension
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// receiver buffers the events posted by the wrapped handler until they are shipped
+type receiver struct {
+	mutex       sync.Mutex
+	events      []*models.EventModel
+	invocations map[string]*invocation
+}
+
+// invocation tracks whether the wrapped handler is done with an invocation
+type invocation struct {
+	done   chan struct{}
+	closed bool
+}
+
+func newReceiver() *receiver {
+	return &receiver{invocations: map[string]*invocation{}}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != EventsPath || req.Method != http.MethodPost {
+		http.NotFound(w, req)
+		return
+	}
+
+	var events []*models.EventModel
+	if err := json.NewDecoder(req.Body).Decode(&events); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mutex.Lock()
+	r.events = append(r.events, events...)
+	if req.Header.Get(InvocationDoneHeader) == "true" {
+		r.invocation(req.Header.Get(RequestIdHeader)).finish()
+	}
+	r.mutex.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Get the invocation, creating it if the handler or the Extensions API didn't mention it yet.
+// The caller must hold the mutex.
+func (r *receiver) invocation(requestId string) *invocation {
+	inv, found := r.invocations[requestId]
+	if !found {
+		inv = &invocation{done: make(chan struct{})}
+		r.invocations[requestId] = inv
+	}
+	return inv
+}
+
+func (inv *invocation) finish() {
+	if !inv.closed {
+		inv.closed = true
+		close(inv.done)
+	}
+}
+
+// Wait until the wrapped handler is done with the invocation, the deadline, if any, is reached or ctx is done.
+// The invocation is then forgotten, along with the done notifications posted after their invocation was given up
+func (r *receiver) wait(ctx context.Context, requestId string, deadline time.Time) {
+	r.mutex.Lock()
+	inv := r.invocation(requestId)
+	r.mutex.Unlock()
+
+	// Without a deadline, only wait for the handler
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-inv.done:
+	case <-expired:
+	case <-ctx.Done():
+	}
+
+	// The invocations are serial, a done invocation other than this one was already waited for
+	r.mutex.Lock()
+	for id, other := range r.invocations {
+		if id == requestId || other.closed {
+			delete(r.invocations, id)
+		}
+	}
+	r.mutex.Unlock()
+}
+
+// Take every buffered event
+func (r *receiver) drain() []*models.EventModel {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
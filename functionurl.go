@@ -0,0 +1,169 @@
+package moesifawslambda
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// Function URLs move the cookies out of the headers, put them back under the given header
+func withCookies(headers map[string]string, header string, cookies []string, separator string) map[string]string {
+	if len(cookies) == 0 {
+		return headers
+	}
+	merged := make(map[string]string, len(headers)+1)
+	for key, value := range headers {
+		merged[key] = value
+	}
+	merged[header] = strings.Join(cookies, separator)
+	return merged
+}
+
+func prepareRequestURIFunctionURL(request events.LambdaFunctionURLRequest) string {
+	var uri string
+	if forwardedProtoHeader, found := request.Headers["x-forwarded-proto"]; found {
+		uri = forwardedProtoHeader
+	} else {
+		// Function URLs are only served over HTTPS
+		uri = "https"
+	}
+
+	uri += "://"
+
+	if len(request.RequestContext.DomainName) > 0 {
+		uri += request.RequestContext.DomainName
+	} else if hostHeader, found := request.Headers["host"]; found {
+		uri += hostHeader
+	} else {
+		uri += "localhost"
+	}
+
+	if len(request.RawPath) > 0 {
+		uri += request.RawPath
+	} else {
+		uri += "/"
+	}
+
+	if len(request.RawQueryString) > 0 {
+		uri += "?" + request.RawQueryString
+	}
+
+	return uri
+}
+
+func defaultSourceIpFunctionURL(request events.LambdaFunctionURLRequest) *string {
+	if len(request.RequestContext.HTTP.SourceIP) > 0 {
+		return &request.RequestContext.HTTP.SourceIP
+	} else {
+		return nil
+	}
+}
+
+// Callers sending a JWT are labelled by its unverified claims
+func getUserIdFunctionURL(request events.LambdaFunctionURLRequest, response events.LambdaFunctionURLResponse) *string {
+	return orTokenUserId(getVerifiedUserIdFunctionURL(request, response), request.Headers)
+}
+
+func getVerifiedUserIdFunctionURL(request events.LambdaFunctionURLRequest, response events.LambdaFunctionURLResponse) *string {
+	var username string
+	if identifyUser := moesifConfig.LambdaFunctionURL.IdentifyUser; identifyUser != nil {
+		username = identifyUser(request, response)
+		return &username
+	}
+
+	// Callers are identified when the Function URL uses the AWS_IAM auth type
+	if request.RequestContext.Authorizer != nil && request.RequestContext.Authorizer.IAM != nil {
+		identity := request.RequestContext.Authorizer.IAM
+		if len(identity.UserID) > 0 {
+			return &identity.UserID
+		}
+		if len(identity.UserARN) > 0 {
+			return &identity.UserARN
+		}
+	}
+	return nil
+}
+
+func getCompanyIdFunctionURL(request events.LambdaFunctionURLRequest, response events.LambdaFunctionURLResponse) string {
+	return orTokenCompanyId(getVerifiedCompanyIdFunctionURL(request, response), request.Headers)
+}
+
+func getVerifiedCompanyIdFunctionURL(request events.LambdaFunctionURLRequest, response events.LambdaFunctionURLResponse) string {
+	if identifyCompany := moesifConfig.LambdaFunctionURL.IdentifyCompany; identifyCompany != nil {
+		return identifyCompany(request, response)
+	}
+	return ""
+}
+
+func prepareEventFunctionURL(request events.LambdaFunctionURLRequest, response events.LambdaFunctionURLResponse, invocation *invocation, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
+	return newIncomingEvent(incomingRequest{
+		time:     invocation.requestTime(request.RequestContext.TimeEpoch),
+		uri:      prepareRequestURIFunctionURL(request),
+		verb:     request.RequestContext.HTTP.Method,
+		headers:  withCookies(request.Headers, "cookie", request.Cookies, "; "),
+		ip:       defaultSourceIpFunctionURL(request),
+		body:     request.Body,
+		isBase64: request.IsBase64Encoded,
+	}, incomingResponse{
+		time:     invocation.end,
+		status:   response.StatusCode,
+		headers:  withCookies(response.Headers, "set-cookie", response.Cookies, ", "),
+		body:     response.Body,
+		isBase64: response.IsBase64Encoded,
+	}, apiVersion, userId, companyId, sessionToken, metadata)
+}
+
+func sendMoesifAsyncFunctionURL(request events.LambdaFunctionURLRequest, response events.LambdaFunctionURLResponse, invocation *invocation) {
+	callbacks := moesifConfig.LambdaFunctionURL
+
+	// A failed invocation is logged with the response the client receives
+	if invocation.failure != nil {
+		statusCode, headers, body := invocation.failure.response()
+		response = events.LambdaFunctionURLResponse{StatusCode: statusCode, Headers: headers, Body: body}
+	}
+
+	// Api Version
+	apiVersion := incomingApiVersion(request.RawPath, "", request.Headers)
+
+	// Get Metadata
+	var metadata map[string]interface{} = nil
+	if callbacks.GetMetadata != nil {
+		metadata = callbacks.GetMetadata(request, response)
+	}
+
+	// Record the failure and the Lambda context of the invocation
+	metadata = withInvocationMetadata(metadata, invocation, request.RequestContext.RequestID)
+
+	// Get User
+	var userId *string
+	userId = getUserIdFunctionURL(request, response)
+
+	// Get Company
+	var companyId string
+	companyId = getCompanyIdFunctionURL(request, response)
+
+	// Get Session Token
+	var sessionToken string
+	if callbacks.GetSessionToken != nil {
+		sessionToken = callbacks.GetSessionToken(request, response)
+	}
+
+	// Sample the event with the rates of the application config
+	weight, sampled := sampleEvent(userId, companyId)
+	if !sampled {
+		return
+	}
+
+	// Prepare Moesif Event
+	moesifEvent := prepareEventFunctionURL(request, response, invocation, apiVersion, userId, companyId, sessionToken, metadata)
+	moesifEvent.Weight = &weight
+
+	// Should skip
+	shouldSkip := false
+	if callbacks.ShouldSkip != nil {
+		shouldSkip = callbacks.ShouldSkip(request, response)
+	}
+
+	sendIncomingEvent(moesifEvent, shouldSkip)
+}
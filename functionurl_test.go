@@ -0,0 +1,74 @@
+package moesifawslambda
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func HandleLambdaEventFunctionURL(ctx context.Context, request events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	return events.LambdaFunctionURLResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"content-type": "application/json"},
+		Body:       request.Body,
+		Cookies:    []string{"session=abc; Path=/"},
+	}, nil
+}
+
+// Generates mock `events.LambdaFunctionURLRequest` request objects.
+func generateFunctionURLReq(body []byte) events.LambdaFunctionURLRequest {
+	return events.LambdaFunctionURLRequest{
+		Version:        "2.0",
+		RawPath:        "/path/to/foo",
+		RawQueryString: "parameter1=value1&parameter1=value2",
+		Cookies:        []string{"cookie1=value1", "cookie2=value2"},
+		Headers:        map[string]string{"x-forwarded-proto": "https", "content-type": "application/json"},
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "abcdefg.lambda-url.us-east-1.on.aws",
+			Authorizer: &events.LambdaFunctionURLRequestContextAuthorizerDescription{
+				IAM: &events.LambdaFunctionURLRequestContextAuthorizerIAMDescription{UserID: "AIDACKCEVSQ6C2EXAMPLE"},
+			},
+			HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{Method: "POST", SourceIP: "72.12.164.125"},
+		},
+		Body: string(body),
+	}
+}
+
+func TestPrepareRequestURIFunctionURL(t *testing.T) {
+	var uri = prepareRequestURIFunctionURL(generateFunctionURLReq(nil))
+
+	var expected = "https://abcdefg.lambda-url.us-east-1.on.aws/path/to/foo?parameter1=value1&parameter1=value2"
+
+	if uri != expected {
+		t.Errorf("got %v, want %v", uri, expected)
+	}
+}
+
+func TestMoesifLoggerFunctionURL(t *testing.T) {
+	resetClient()
+	// The cookie header is redacted by default
+	handler := MoesifLogger(HandleLambdaEventFunctionURL, MoesifOptions(), WithRedactHeaders("Authorization")).(func(context.Context, events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error))
+
+	if _, err := handler(context.Background(), generateFunctionURLReq([]byte(`{"foo": "bar"}`))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	event := sent[0]
+	if event.UserId == nil || *event.UserId != "AIDACKCEVSQ6C2EXAMPLE" {
+		t.Errorf("got user %v, want the IAM user id", event.UserId)
+	}
+	if event.Request.IpAddress == nil || *event.Request.IpAddress != "72.12.164.125" {
+		t.Errorf("got ip %v, want 72.12.164.125", event.Request.IpAddress)
+	}
+	if cookie := event.Request.Headers.(map[string]string)["cookie"]; cookie != "cookie1=value1; cookie2=value2" {
+		t.Errorf("got cookie header %q, want the request cookies", cookie)
+	}
+	if setCookie := event.Response.Headers.(map[string]string)["set-cookie"]; setCookie != "session=abc; Path=/" {
+		t.Errorf("got set-cookie header %q, want the response cookies", setCookie)
+	}
+}
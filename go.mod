@@ -1,8 +1,9 @@
 module github.com/moesif/moesif-aws-lambda-go
 
-go 1.12
+go 1.18
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/aws/aws-lambda-go v1.47.0
 	github.com/moesif/moesifapi-go v1.0.3
 	golang.org/x/net v0.0.0-20200202094626-16171245cfb2 // indirect
@@ -0,0 +1,400 @@
+package moesifawslambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	moesifapi "github.com/moesif/moesifapi-go"
+)
+
+// Endpoint of the governance rules, replaced in tests
+var governanceRulesURL = moesifapi.BaseURI + "/v1/rules"
+
+// How the governance rules of the application are applied
+const (
+	// The rules are not fetched
+	GovernanceOff = "off"
+	// The requests matching a blocking rule get the response of the rule, the handler is not called
+	GovernanceEnforce = "enforce"
+	// The handler is always called, the events of the requests matching a blocking rule are annotated
+	GovernanceDryRun = "dry_run"
+)
+
+// Types of governance rules
+const (
+	governanceRuleRegex   = "regex"
+	governanceRuleUser    = "user"
+	governanceRuleCompany = "company"
+)
+
+// The cache of the governance rules of the initialized client
+var governanceRules = newRemoteCache[[]governanceRule](governanceRulesURL, "")
+
+// governanceRule is a rule of the application in Moesif
+type governanceRule struct {
+	Id   string `json:"_id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Rules that don't block only change the responses in the Moesif gateways
+	Block bool `json:"block"`
+	// A user or company rule applies to the members of its cohort, matching, or to the others, not_matching
+	AppliedTo             string `json:"applied_to"`
+	AppliedToUnidentified bool   `json:"applied_to_unidentified"`
+	// The rule applies to the requests matching any of the groups, when there are some
+	RegexConfig []governanceRegexGroup `json:"regex_config"`
+	Response    governanceResponse     `json:"response"`
+}
+
+// A request matches a group when it matches all of its conditions
+type governanceRegexGroup struct {
+	Conditions []governanceCondition `json:"conditions"`
+}
+
+// The field of the request at the path, such as request.route or request.headers.content-type, must match the value
+type governanceCondition struct {
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// The response of a blocked request. {{name}} in the headers and the body is replaced with the value of the cohort
+type governanceResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// The rules and their values for a user or company of the application config
+type governanceRuleValues struct {
+	Rules  string            `json:"rules"`
+	Values map[string]string `json:"values"`
+}
+
+// Payload independent view of a request evaluated against the governance rules
+type governedRequest struct {
+	route     string
+	verb      string
+	ip        string
+	headers   http.Header
+	userId    *string
+	companyId string
+}
+
+// The blocking rule matched by a request
+type governanceDecision struct {
+	rule   governanceRule
+	values map[string]string
+	dryRun bool
+}
+
+// The compiled patterns of the conditions
+var governancePatterns sync.Map
+
+func newGovernedRequest(path string, verb string, headers map[string]string, sourceIp *string, userId *string, companyId string) *governedRequest {
+	request := &governedRequest{route: path, verb: verb, headers: httpHeader(headers), userId: userId, companyId: companyId}
+	if ip := getClientIp(request.headers, sourceIp); ip != nil {
+		request.ip = *ip
+	}
+	return request
+}
+
+// The value of a field of the request
+func (r *governedRequest) field(path string) (string, bool) {
+	switch path {
+	case "request.route":
+		return r.route, true
+	case "request.verb":
+		return r.verb, true
+	case "request.ip_address":
+		return r.ip, true
+	}
+	if strings.HasPrefix(path, "request.headers.") {
+		return r.headers.Get(strings.TrimPrefix(path, "request.headers.")), true
+	}
+	return "", false
+}
+
+func (c governanceCondition) matches(request *governedRequest) bool {
+	value, known := request.field(c.Path)
+	if !known {
+		return false
+	}
+	pattern, found := governancePatterns.Load(c.Value)
+	if !found {
+		compiled, err := regexp.Compile(c.Value)
+		if err != nil {
+			if debug {
+				log.Printf("Invalid pattern %q in a governance rule: %s", c.Value, err.Error())
+			}
+			return false
+		}
+		pattern, _ = governancePatterns.LoadOrStore(c.Value, compiled)
+	}
+	return pattern.(*regexp.Regexp).MatchString(value)
+}
+
+func (r governanceRule) matchesRegexConfig(request *governedRequest) bool {
+	for _, group := range r.RegexConfig {
+		matched := len(group.Conditions) > 0
+		for _, condition := range group.Conditions {
+			if !condition.matches(request) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the rule applies to the request, with the values of the cohort of the user or company
+func (r governanceRule) matches(request *governedRequest, config appConfig) (map[string]string, bool) {
+	if !r.Block {
+		return nil, false
+	}
+
+	var id string
+	var identified bool
+	var cohorts map[string][]governanceRuleValues
+	switch r.Type {
+	case governanceRuleRegex:
+		return nil, r.matchesRegexConfig(request)
+	case governanceRuleUser:
+		if request.userId != nil {
+			id, identified = *request.userId, *request.userId != ""
+		}
+		cohorts = config.UserRules
+	case governanceRuleCompany:
+		id, identified = request.companyId, request.companyId != ""
+		cohorts = config.CompanyRules
+	default:
+		return nil, false
+	}
+
+	if len(r.RegexConfig) > 0 && !r.matchesRegexConfig(request) {
+		return nil, false
+	}
+	if !identified {
+		return nil, r.AppliedToUnidentified
+	}
+	var values map[string]string
+	member := false
+	for _, entry := range cohorts[id] {
+		if entry.Rules == r.Id {
+			values, member = entry.Values, true
+			break
+		}
+	}
+	if r.AppliedTo == "not_matching" {
+		return nil, !member
+	}
+	return values, member
+}
+
+// The first blocking rule the request matches, nil when governance is off or no rule matches.
+// The request is only built when there are rules to evaluate
+func governRequest(request func() *governedRequest) *governanceDecision {
+	if moesifConfig.Governance == GovernanceOff || request == nil {
+		return nil
+	}
+	rules := governanceRules.loaded()
+	if len(rules) == 0 {
+		return nil
+	}
+	governed := request()
+	if governed == nil {
+		return nil
+	}
+
+	config := remoteConfig.loaded()
+	for _, rule := range rules {
+		if values, matched := rule.matches(governed, config); matched {
+			if debug {
+				log.Printf("The request matches the governance rule %s", rule.Id)
+			}
+			return &governanceDecision{rule: rule, values: values, dryRun: moesifConfig.Governance == GovernanceDryRun}
+		}
+	}
+	return nil
+}
+
+// Build the request once, when the rules or the rate limiter first need it
+func lazyGovernedRequest(request func() *governedRequest) func() *governedRequest {
+	if request == nil {
+		return nil
+	}
+	var once sync.Once
+	var governed *governedRequest
+	return func() *governedRequest {
+		once.Do(func() { governed = request() })
+		return governed
+	}
+}
+
+// Call and time the handler unless the request is blocked by a governance rule or the rate limiter
+func callGovernedHandler(ctx context.Context, request func() *governedRequest, call func() error) *invocation {
+	request = lazyGovernedRequest(request)
+	decision := governRequest(request)
+	if decision == nil || decision.dryRun {
+		// A request annotated in dry run is still rate limited
+		if limited := rateLimitRequest(ctx, request); limited != nil {
+			decision = limited
+		}
+	}
+	if decision != nil && !decision.dryRun {
+		invocation := newInvocation(ctx)
+		invocation.governance = decision
+		invocation.end = time.Now()
+		return invocation
+	}
+
+	invocation := callHandler(ctx, call)
+	invocation.governance = decision
+	return invocation
+}
+
+// Whether the handler was not called because of a governance rule
+func (i *invocation) blocked() bool {
+	return i.governance != nil && !i.governance.dryRun
+}
+
+// Replace the {{name}} placeholders with the values of the cohort
+func (d *governanceDecision) substitute(text string) string {
+	for name, value := range d.values {
+		text = strings.ReplaceAll(text, "{{"+name+"}}", value)
+	}
+	return text
+}
+
+// The response of the rule the client receives, a JSON body unless the rule has a string body
+func (d *governanceDecision) response() (int, map[string]string, string) {
+	statusCode := d.rule.Response.Status
+	if statusCode == 0 {
+		statusCode = http.StatusForbidden
+	}
+
+	headers := make(map[string]string, len(d.rule.Response.Headers)+1)
+	for name, value := range d.rule.Response.Headers {
+		headers[name] = d.substitute(value)
+	}
+
+	var body string
+	if len(d.rule.Response.Body) > 0 && string(d.rule.Response.Body) != "null" {
+		if err := json.Unmarshal(d.rule.Response.Body, &body); err != nil {
+			body = string(d.rule.Response.Body)
+			if headerValue(headers, "Content-Type") == "" {
+				headers["Content-Type"] = "application/json"
+			}
+		}
+		body = d.substitute(body)
+	}
+	return statusCode, headers, body
+}
+
+func (d *governanceDecision) metadata() map[string]interface{} {
+	return map[string]interface{}{
+		"rule_id":   d.rule.Id,
+		"rule_name": d.rule.Name,
+		"dry_run":   d.dryRun,
+	}
+}
+
+// The requests are only identified by the callbacks or the authorizer, the unverified JWT of a request could be forged
+func governedRequestV1(request events.APIGatewayProxyRequest) func() *governedRequest {
+	return func() *governedRequest {
+		// The response is empty, the handler is not called yet
+		var response events.APIGatewayProxyResponse
+		return newGovernedRequest(request.Path, request.HTTPMethod, request.Headers, defaultSourceIp(request), getVerifiedUserId(request, response), getVerifiedCompanyId(request, response))
+	}
+}
+
+func governedRequestV2HTTP(request events.APIGatewayV2HTTPRequest) func() *governedRequest {
+	return func() *governedRequest {
+		var response events.APIGatewayV2HTTPResponse
+		return newGovernedRequest(request.RawPath, request.RequestContext.HTTP.Method, request.Headers, defaultSourceIpV2HTTP(request), getVerifiedUserIdV2HTTP(request, response), getVerifiedCompanyIdV2HTTP(request, response))
+	}
+}
+
+func governedRequestALB(request events.ALBTargetGroupRequest) func() *governedRequest {
+	return func() *governedRequest {
+		var response events.ALBTargetGroupResponse
+		return newGovernedRequest(request.Path, request.HTTPMethod, albHeaders(request.Headers, request.MultiValueHeaders), nil, getVerifiedUserIdALB(request, response), getVerifiedCompanyIdALB(request, response))
+	}
+}
+
+func governedRequestFunctionURL(request events.LambdaFunctionURLRequest) func() *governedRequest {
+	return func() *governedRequest {
+		var response events.LambdaFunctionURLResponse
+		return newGovernedRequest(request.RawPath, request.RequestContext.HTTP.Method, request.Headers, defaultSourceIpFunctionURL(request), getVerifiedUserIdFunctionURL(request, response), getVerifiedCompanyIdFunctionURL(request, response))
+	}
+}
+
+func governedRequestWebsocket(request events.APIGatewayWebsocketProxyRequest) func() *governedRequest {
+	return func() *governedRequest {
+		var response events.APIGatewayProxyResponse
+		return newGovernedRequest(request.RequestContext.RouteKey, verbWebsocket(request), request.Headers, defaultSourceIpWebsocket(request), getVerifiedUserIdWebsocket(request, response), getVerifiedCompanyIdWebsocket(request, response))
+	}
+}
+
+// The request of a raw JSON payload evaluated against the governance rules, nil if it's not an HTTP event
+func governedPayload(payload func() []byte) func() *governedRequest {
+	return func() *governedRequest {
+		data := payload()
+		switch detectPayload(data) {
+		case payloadAPIGatewayProxy:
+			var request events.APIGatewayProxyRequest
+			json.Unmarshal(data, &request)
+			return governedRequestV1(request)()
+		case payloadAPIGatewayV2HTTP:
+			var request events.APIGatewayV2HTTPRequest
+			json.Unmarshal(data, &request)
+			return governedRequestV2HTTP(request)()
+		case payloadALBTargetGroup:
+			var request events.ALBTargetGroupRequest
+			json.Unmarshal(data, &request)
+			return governedRequestALB(request)()
+		case payloadFunctionURL:
+			var request events.LambdaFunctionURLRequest
+			json.Unmarshal(data, &request)
+			return governedRequestFunctionURL(request)()
+		case payloadWebsocket:
+			var request events.APIGatewayWebsocketProxyRequest
+			json.Unmarshal(data, &request)
+			return governedRequestWebsocket(request)()
+		default:
+			return nil
+		}
+	}
+}
+
+// The response payload of a blocked request, in the response type of the payload
+func blockedResponsePayload(payload []byte, decision *governanceDecision) []byte {
+	statusCode, headers, body := decision.response()
+	var response interface{}
+	switch detectPayload(payload) {
+	case payloadAPIGatewayV2HTTP:
+		response = events.APIGatewayV2HTTPResponse{StatusCode: statusCode, Headers: headers, Body: body}
+	case payloadALBTargetGroup:
+		response = events.ALBTargetGroupResponse{
+			StatusCode:        statusCode,
+			StatusDescription: fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			Headers:           headers,
+			Body:              body,
+		}
+	case payloadFunctionURL:
+		response = events.LambdaFunctionURLResponse{StatusCode: statusCode, Headers: headers, Body: body}
+	default:
+		response = events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: body}
+	}
+	encoded, _ := json.Marshal(response)
+	return encoded
+}
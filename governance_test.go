@@ -0,0 +1,114 @@
+package moesifawslambda
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const testGovernanceRules = `[
+	{"_id": "blocked-users", "name": "Over quota", "type": "user", "block": true, "applied_to": "matching",
+	 "response": {"status": 429, "headers": {"X-Quota": "{{0}}"}, "body": {"error": "Quota of {{0}} requests exceeded"}}},
+	{"_id": "admin", "name": "No admin", "type": "regex", "block": true,
+	 "regex_config": [{"conditions": [{"path": "request.route", "value": "^/admin"}, {"path": "request.verb", "value": "DELETE"}]}],
+	 "response": {"status": 403, "body": "Forbidden"}},
+	{"_id": "headers", "name": "Headers only", "type": "regex", "block": false,
+	 "regex_config": [{"conditions": [{"path": "request.route", "value": ".*"}]}]}
+]`
+
+func TestGovernanceRuleMatches(t *testing.T) {
+	var rules []governanceRule
+	if err := json.Unmarshal([]byte(testGovernanceRules), &rules); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config := appConfig{UserRules: map[string][]governanceRuleValues{
+		"alice": {{Rules: "blocked-users", Values: map[string]string{"0": "1000"}}},
+	}}
+	alice, bob := "alice", "bob"
+
+	tests := []struct {
+		rule     governanceRule
+		request  governedRequest
+		expected bool
+	}{
+		{rules[0], governedRequest{userId: &alice}, true},
+		{rules[0], governedRequest{userId: &bob}, false},
+		{rules[0], governedRequest{}, false},
+		{governanceRule{Id: "blocked-users", Type: "user", Block: true, AppliedTo: "not_matching"}, governedRequest{userId: &bob}, true},
+		{governanceRule{Id: "blocked-users", Type: "user", Block: true, AppliedToUnidentified: true}, governedRequest{}, true},
+		{rules[1], governedRequest{route: "/admin/users", verb: "DELETE"}, true},
+		{rules[1], governedRequest{route: "/admin/users", verb: "GET"}, false},
+		{rules[2], governedRequest{route: "/users"}, false},
+		{governanceRule{Type: "regex", Block: true, RegexConfig: []governanceRegexGroup{{Conditions: []governanceCondition{{Path: "request.headers.x-api-key", Value: "^revoked"}}}}},
+			governedRequest{headers: httpHeader(map[string]string{"X-Api-Key": "revoked-1"})}, true},
+	}
+
+	for i, test := range tests {
+		request := test.request
+		if request.headers == nil {
+			request.headers = httpHeader(nil)
+		}
+		if _, matched := test.rule.matches(&request, config); matched != test.expected {
+			t.Errorf("got %v for the rule %s and the request %d, want %v", matched, test.rule.Id, i, test.expected)
+		}
+	}
+}
+
+func TestMoesifLoggerEnforcesGovernanceRules(t *testing.T) {
+	rules := &configServer{}
+	rules.set(testGovernanceRules, "rules-1")
+	rulesServer := httptest.NewServer(rules)
+	defer rulesServer.Close()
+	defer func(url string) { governanceRulesURL = url }(governanceRulesURL)
+	governanceRulesURL = rulesServer.URL
+
+	config := &configServer{}
+	config.set(`{"user_rules": {"alice": [{"rules": "blocked-users", "values": {"0": "1000"}}]}}`, "config-1")
+	configServer := httptest.NewServer(config)
+	defer configServer.Close()
+	defer func(url string) { appConfigURL = url }(appConfigURL)
+	appConfigURL = configServer.URL
+
+	t.Setenv("MOESIF_APPLICATION_ID", "app-id")
+	for _, mode := range []string{GovernanceEnforce, GovernanceDryRun} {
+		resetClient()
+		called := false
+		handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			called = true
+			return HandleLambdaEvent(ctx, request)
+		}, MoesifOptions(), WithGovernance(mode), WithAPIGatewayProxyCallbacks(APIGatewayProxyCallbacks{
+			IdentifyUser: func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string { return "alice" },
+		})).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+		response, err := handler(context.Background(), generateProxyReq(nil, false))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if mode == GovernanceEnforce {
+			if called {
+				t.Errorf("the handler was called for a blocked request")
+			}
+			if response.StatusCode != 429 || response.Headers["X-Quota"] != "1000" || response.Body != `{"error": "Quota of 1000 requests exceeded"}` {
+				t.Errorf("got response %+v, want the response of the rule", response)
+			}
+		} else if !called || response.StatusCode != 200 {
+			t.Errorf("got response %+v, want the response of the handler in dry run", response)
+		}
+
+		sent := testAPI.events()
+		if len(sent) != 1 {
+			t.Fatalf("got %d events sent, want 1", len(sent))
+		}
+		if status := sent[0].Response.Status; status != response.StatusCode {
+			t.Errorf("got status %d logged, want %d", status, response.StatusCode)
+		}
+		blockedBy, ok := (*sent[0].Metadata.(*map[string]interface{}))["blocked_by"].(map[string]interface{})
+		if !ok || blockedBy["rule_id"] != "blocked-users" || blockedBy["dry_run"] != (mode == GovernanceDryRun) {
+			t.Errorf("got blocked_by %v in %s mode, want the rule", blockedBy, mode)
+		}
+	}
+}
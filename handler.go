@@ -0,0 +1,244 @@
+package moesifawslambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// moesifHandler logs the invocations of a lambda.Handler from their raw JSON payloads
+type moesifHandler struct {
+	handler lambda.Handler
+	config  *Config
+}
+
+func (h *moesifHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	// Initialize the Moesif client if not already initialized
+	if apiClient == nil {
+		moesifClient(h.config)
+	}
+
+	// Call the handler unless a governance rule blocks the request, then send data to Moesif.
+	// An error or a panic is logged as the response the client receives
+	var response []byte
+	var err error
+	invocation := callGovernedHandler(ctx, governedPayload(func() []byte { return payload }), func() error {
+		response, err = h.handler.Invoke(ctx, payload)
+		return err
+	})
+	if invocation.blocked() {
+		response = blockedResponsePayload(payload, invocation.governance)
+	}
+	sendMoesifAsyncPayload(payload, response, invocation)
+
+	// Make sure the events are sent before the execution environment is frozen
+	flushInvocation(ctx)
+	invocation.failure.repanic()
+	return response, err
+}
+
+// Wrap any handler lambda.Start accepts, the payload type is detected on every invocation
+func wrapLambdaHandler(f interface{}, config *Config) (lambda.Handler, error) {
+	if handler, ok := f.(lambda.Handler); ok {
+		return &moesifHandler{handler: handler, config: config}, nil
+	}
+	if err := validateHandlerSignature(f); err != nil {
+		return nil, err
+	}
+	return &moesifHandler{handler: lambda.NewHandler(f), config: config}, nil
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Check the handler has a signature lambda.Start accepts, which lambda.NewHandler only reports when it is invoked
+func validateHandlerSignature(f interface{}) error {
+	if f == nil || reflect.TypeOf(f).Kind() != reflect.Func {
+		return errors.New("unsupported handler type")
+	}
+	handlerType := reflect.TypeOf(f)
+	switch handlerType.NumIn() {
+	case 0, 1:
+	case 2:
+		if !handlerType.In(0).Implements(contextType) {
+			return fmt.Errorf("handler takes two arguments, but the first is not a context.Context, got %s", handlerType.In(0))
+		}
+	default:
+		return fmt.Errorf("handler may not take more than two arguments, got %d", handlerType.NumIn())
+	}
+	switch handlerType.NumOut() {
+	case 0:
+	case 1, 2:
+		if last := handlerType.Out(handlerType.NumOut() - 1); !last.Implements(errorType) {
+			return fmt.Errorf("handler must return an error as its last value, got %s", last)
+		}
+	default:
+		return fmt.Errorf("handler may not return more than two values, got %d", handlerType.NumOut())
+	}
+	return nil
+}
+
+// Wrap a handler of any request and response types, returning an error if the configuration is invalid.
+// The API Gateway, ALB and Function URL event types use their event builder directly, other types are
+// encoded to JSON after the call to detect the payload.
+func Wrap[Req, Resp any](handler func(context.Context, Req) (Resp, error), opts ...Option) (func(context.Context, Req) (Resp, error), error) {
+	config, err := NewConfig(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrapHandler(handler, config)
+	if err != nil {
+		return nil, err
+	}
+	if typed, ok := wrapped.(func(context.Context, Req) (Resp, error)); ok {
+		return typed, nil
+	}
+
+	return func(ctx context.Context, request Req) (Resp, error) {
+		// Initialize the Moesif client if not already initialized
+		if apiClient == nil {
+			moesifClient(config)
+		}
+
+		// Call the handler unless a governance rule blocks the request, then send data to Moesif.
+		// An error or a panic is logged as the response the client receives
+		var response Resp
+		var err error
+		payload, requestErr := json.Marshal(request)
+		invocation := callGovernedHandler(ctx, governedPayload(func() []byte { return payload }), func() error {
+			response, err = handler(ctx, request)
+			return err
+		})
+		if invocation.blocked() {
+			// The response of the rule is decoded into the response type of the handler
+			json.Unmarshal(blockedResponsePayload(payload, invocation.governance), &response)
+		}
+		responsePayload, responseErr := json.Marshal(response)
+		if requestErr == nil && responseErr == nil {
+			sendMoesifAsyncPayload(payload, responsePayload, invocation)
+		} else if debug {
+			log.Printf("Skip sending the event to Moesif, the payloads can't be encoded to JSON")
+		}
+
+		// Make sure the events are sent before the execution environment is frozen
+		flushInvocation(ctx)
+		invocation.failure.repanic()
+		return response, err
+	}, nil
+}
+
+// The fields telling the event sources apart
+type payloadShape struct {
+	Version        string `json:"version"`
+	HTTPMethod     string `json:"httpMethod"`
+	RequestContext struct {
+		ELB          json.RawMessage `json:"elb"`
+		ConnectionID string          `json:"connectionId"`
+		DomainName   string          `json:"domainName"`
+	} `json:"requestContext"`
+}
+
+// Detect the event source of a raw payload, returns an empty string if it's not an HTTP event
+func detectPayload(payload []byte) string {
+	var shape payloadShape
+	if err := json.Unmarshal(payload, &shape); err != nil {
+		return ""
+	}
+
+	switch {
+	case len(shape.RequestContext.ELB) > 0:
+		return payloadALBTargetGroup
+	case len(shape.RequestContext.ConnectionID) > 0:
+		return payloadWebsocket
+	case shape.Version == "2.0" && strings.Contains(shape.RequestContext.DomainName, ".lambda-url."):
+		return payloadFunctionURL
+	case shape.Version == "2.0":
+		return payloadAPIGatewayV2HTTP
+	case len(shape.HTTPMethod) > 0:
+		return payloadAPIGatewayProxy
+	default:
+		return ""
+	}
+}
+
+// HTTP APIs and Function URLs accept any JSON as response, without a status code it is the body of a 200 response
+func inferResponse(responsePayload []byte) (map[string]string, string, bool) {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(responsePayload, &response); err == nil {
+		if _, found := response["statusCode"]; found {
+			return nil, "", false
+		}
+	}
+	return map[string]string{"content-type": "application/json"}, string(responsePayload), true
+}
+
+// Log an invocation from its raw JSON payloads with the event builder of the detected payload
+func sendMoesifAsyncPayload(payload []byte, responsePayload []byte, invocation *invocation) {
+	// The response of a failed invocation is replaced with the response the client receives
+	hasResponse := len(responsePayload) > 0 && invocation.failure == nil
+
+	switch detectPayload(payload) {
+	case payloadAPIGatewayProxy:
+		var request events.APIGatewayProxyRequest
+		var response events.APIGatewayProxyResponse
+		json.Unmarshal(payload, &request)
+		if hasResponse {
+			json.Unmarshal(responsePayload, &response)
+		}
+		sendMoesifAsync(request, response, invocation)
+	case payloadAPIGatewayV2HTTP:
+		var request events.APIGatewayV2HTTPRequest
+		var response events.APIGatewayV2HTTPResponse
+		json.Unmarshal(payload, &request)
+		if hasResponse {
+			if headers, body, inferred := inferResponse(responsePayload); inferred {
+				response = events.APIGatewayV2HTTPResponse{StatusCode: 200, Headers: headers, Body: body}
+			} else {
+				json.Unmarshal(responsePayload, &response)
+			}
+		}
+		sendMoesifAsyncV2HTTP(request, response, invocation)
+	case payloadALBTargetGroup:
+		var request events.ALBTargetGroupRequest
+		var response events.ALBTargetGroupResponse
+		json.Unmarshal(payload, &request)
+		if hasResponse {
+			json.Unmarshal(responsePayload, &response)
+		}
+		sendMoesifAsyncALB(request, response, invocation)
+	case payloadFunctionURL:
+		var request events.LambdaFunctionURLRequest
+		var response events.LambdaFunctionURLResponse
+		json.Unmarshal(payload, &request)
+		if hasResponse {
+			if headers, body, inferred := inferResponse(responsePayload); inferred {
+				response = events.LambdaFunctionURLResponse{StatusCode: 200, Headers: headers, Body: body}
+			} else {
+				json.Unmarshal(responsePayload, &response)
+			}
+		}
+		sendMoesifAsyncFunctionURL(request, response, invocation)
+	case payloadWebsocket:
+		var request events.APIGatewayWebsocketProxyRequest
+		var response events.APIGatewayProxyResponse
+		json.Unmarshal(payload, &request)
+		if hasResponse {
+			json.Unmarshal(responsePayload, &response)
+		}
+		sendMoesifAsyncWebsocket(request, response, invocation)
+	default:
+		if debug {
+			log.Printf("Skip sending the event to Moesif, the payload is not an HTTP event")
+		}
+	}
+}
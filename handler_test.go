@@ -0,0 +1,107 @@
+package moesifawslambda
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func TestDetectPayload(t *testing.T) {
+	payloads := map[string]interface{}{
+		payloadAPIGatewayProxy:  generateProxyReq(nil, false),
+		payloadAPIGatewayV2HTTP: generateProxyReqV2HTTP(nil, false),
+		payloadALBTargetGroup:   events.ALBTargetGroupRequest{HTTPMethod: "GET", RequestContext: events.ALBTargetGroupRequestContext{ELB: events.ELBContext{TargetGroupArn: "arn"}}},
+		payloadFunctionURL:      generateFunctionURLReq(nil),
+		payloadWebsocket:        generateWebsocketReq(nil),
+		"":                      events.SQSEvent{},
+	}
+	for expected, request := range payloads {
+		payload, _ := json.Marshal(request)
+		if detected := detectPayload(payload); detected != expected {
+			t.Errorf("got %q for %T, want %q", detected, request, expected)
+		}
+	}
+}
+
+func TestMoesifLoggerWrapsAnyHandlerShape(t *testing.T) {
+	resetClient()
+	// A handler without a context argument is wrapped as a lambda.Handler
+	handler := MoesifLogger(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: 201}, nil
+	}, MoesifOptions()).(lambda.Handler)
+
+	payload, _ := json.Marshal(generateProxyReq([]byte(`{"foo": "bar"}`), false))
+	if _, err := handler.Invoke(context.Background(), payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if sent[0].Request.Verb != "POST" || sent[0].Response.Status != 201 {
+		t.Errorf("got %s with status %d, want POST with status 201", sent[0].Request.Verb, sent[0].Response.Status)
+	}
+}
+
+func TestNewMoesifLoggerRejectsInvalidHandlerSignatures(t *testing.T) {
+	handlers := []interface{}{
+		nil,
+		"handler",
+		func(request events.APIGatewayProxyRequest, ctx context.Context) error { return nil },
+		func(ctx context.Context, request events.APIGatewayProxyRequest, other string) error { return nil },
+		func(ctx context.Context) string { return "" },
+		func(ctx context.Context) (string, string) { return "", "" },
+		func(ctx context.Context) (string, string, error) { return "", "", nil },
+	}
+	for _, handler := range handlers {
+		if _, err := NewMoesifLogger(handler); err == nil {
+			t.Errorf("got no error for the handler %T, want an error", handler)
+		}
+	}
+
+	valid := []interface{}{
+		func() {},
+		func(ctx context.Context) error { return nil },
+		func(request map[string]interface{}) (string, error) { return "", nil },
+		func(ctx context.Context, request map[string]interface{}) (interface{}, error) { return nil, nil },
+	}
+	for _, handler := range valid {
+		if _, err := NewMoesifLogger(handler); err != nil {
+			t.Errorf("got %v for the handler %T, want no error", err, handler)
+		}
+	}
+}
+
+func TestWrapDetectsThePayloadOfGenericTypes(t *testing.T) {
+	resetClient()
+	// The response has no status code, Function URLs return it as the body of a 200 response
+	handler, err := Wrap(func(ctx context.Context, request map[string]interface{}) (map[string]string, error) {
+		return map[string]string{"message": "hello"}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	payload, _ := json.Marshal(generateFunctionURLReq(nil))
+	var request map[string]interface{}
+	json.Unmarshal(payload, &request)
+
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if sent[0].Response.Status != 200 {
+		t.Errorf("got status %d, want 200", sent[0].Response.Status)
+	}
+	if body, _ := (*sent[0].Response.Body.(*interface{})).(map[string]interface{}); body["message"] != "hello" {
+		t.Errorf("got body %v, want the response of the handler", sent[0].Response.Body)
+	}
+}
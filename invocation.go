@@ -0,0 +1,158 @@
+package moesifawslambda
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// The fields of the Lambda context recorded under the lambda key of the metadata
+var lambdaContextFields = []string{
+	"function_name",
+	"function_version",
+	"alias",
+	"invoked_function_arn",
+	"aws_request_id",
+	"api_gateway_request_id",
+	"memory_size",
+	"region",
+	"log_group",
+	"log_stream",
+	"cold_start",
+	"remaining_time_ms",
+}
+
+// True until the first invocation of the execution environment
+var coldStart = true
+
+// invocation is a call of the wrapped handler
+type invocation struct {
+	ctx       context.Context
+	start     time.Time
+	end       time.Time
+	coldStart bool
+	failure   *handlerFailure
+	// The governance rule matched by the request
+	governance *governanceDecision
+}
+
+// Call and time the handler, recovering a panic so that the invocation is logged before the panic is propagated
+func callHandler(ctx context.Context, call func() error) (invocation *invocation) {
+	invocation = newInvocation(ctx)
+	if !moesifConfig.CaptureErrors {
+		call()
+		invocation.end = time.Now()
+		return invocation
+	}
+
+	defer func() {
+		invocation.end = time.Now()
+		if r := recover(); r != nil {
+			invocation.failure = &handlerFailure{panicked: true, panicValue: r}
+		}
+	}()
+	if err := call(); err != nil {
+		invocation.failure = &handlerFailure{err: err}
+	}
+	return invocation
+}
+
+func newInvocation(ctx context.Context) *invocation {
+	invocation := &invocation{ctx: ctx, start: time.Now(), coldStart: coldStart}
+	coldStart = false
+	return invocation
+}
+
+// The time API Gateway received the request, in milliseconds since the epoch, or the time the handler was called
+func (i *invocation) requestTime(epochMs int64) time.Time {
+	if epochMs > 0 {
+		received := time.Unix(0, epochMs*int64(time.Millisecond))
+		if !received.After(i.end) {
+			return received
+		}
+	}
+	return i.start
+}
+
+// The alias the function was invoked with, from the qualifier of its ARN
+func functionAlias(invokedFunctionArn string) string {
+	// arn:aws:lambda:us-east-1:123456789012:function:my-function:alias
+	parts := strings.Split(invokedFunctionArn, ":")
+	if len(parts) != 8 {
+		return ""
+	}
+	qualifier := parts[7]
+	if _, err := strconv.Atoi(qualifier); err == nil || qualifier == "$LATEST" {
+		// Invoked with a version rather than an alias
+		return ""
+	}
+	return qualifier
+}
+
+// Describe the execution of the function to correlate the event with its CloudWatch logs
+func (i *invocation) lambdaContext(apiRequestId string) map[string]interface{} {
+	fields := map[string]interface{}{
+		"function_name":          lambdacontext.FunctionName,
+		"function_version":       lambdacontext.FunctionVersion,
+		"api_gateway_request_id": apiRequestId,
+		"region":                 os.Getenv("AWS_REGION"),
+		"log_group":              lambdacontext.LogGroupName,
+		"log_stream":             lambdacontext.LogStreamName,
+		"cold_start":             i.coldStart,
+	}
+	if lambdacontext.MemoryLimitInMB > 0 {
+		fields["memory_size"] = lambdacontext.MemoryLimitInMB
+	}
+	if lc, ok := lambdacontext.FromContext(i.ctx); ok {
+		fields["aws_request_id"] = lc.AwsRequestID
+		fields["invoked_function_arn"] = lc.InvokedFunctionArn
+		fields["alias"] = functionAlias(lc.InvokedFunctionArn)
+	}
+	if deadline, ok := i.ctx.Deadline(); ok {
+		fields["remaining_time_ms"] = deadline.Sub(i.end).Milliseconds()
+	}
+
+	// Keep the selected fields that are known
+	selected := lambdaContextFields
+	if len(moesifConfig.LambdaContextFields) > 0 {
+		selected = moesifConfig.LambdaContextFields
+	}
+	lambda := make(map[string]interface{}, len(selected))
+	for _, field := range selected {
+		if value, found := fields[field]; found && value != "" {
+			lambda[field] = value
+		}
+	}
+	return lambda
+}
+
+// Set a key of the metadata unless the Get_Metadata callback already sets it, without changing the map of the callback
+func withMetadata(metadata map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	if _, found := metadata[key]; found {
+		return metadata
+	}
+	merged := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	merged[key] = value
+	return merged
+}
+
+// Record the failure of the invocation under the error key and the Lambda context under the lambda key of the metadata
+func withInvocationMetadata(metadata map[string]interface{}, invocation *invocation, apiRequestId string) map[string]interface{} {
+	if invocation.failure != nil {
+		metadata = withMetadata(metadata, "error", invocation.failure.metadata())
+	}
+	if invocation.governance != nil {
+		metadata = withMetadata(metadata, "blocked_by", invocation.governance.metadata())
+	}
+	if moesifConfig.LogLambdaContext {
+		metadata = withMetadata(metadata, "lambda", invocation.lambdaContext(apiRequestId))
+	}
+	return metadata
+}
@@ -0,0 +1,75 @@
+package moesifawslambda
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func lambdaContextMetadata(t *testing.T, metadata interface{}) map[string]interface{} {
+	lambda, ok := (*metadata.(*map[string]interface{}))["lambda"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got metadata %v, want a lambda entry", metadata)
+	}
+	return lambda
+}
+
+func invokeWithLambdaContext(t *testing.T, opts ...Option) map[string]interface{} {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions(), opts...).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ctx = lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{
+		AwsRequestID:       "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+		InvokedFunctionArn: "arn:aws:lambda:us-east-1:123456789012:function:my-function:prod",
+	})
+	request := generateProxyReq(nil, false)
+	request.RequestContext.RequestID = "c6af9ac6-7b61-11e6-9a41-93e812345678"
+
+	if _, err := handler(ctx, request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	return lambdaContextMetadata(t, sent[0].Metadata)
+}
+
+func TestMoesifLoggerRecordsTheLambdaContext(t *testing.T) {
+	coldStart = true
+	lambda := invokeWithLambdaContext(t)
+
+	if lambda["aws_request_id"] != "c6af9ac6-7b61-11e6-9a41-93e8deadbeef" {
+		t.Errorf("got aws request id %v, want the request id of the invocation", lambda["aws_request_id"])
+	}
+	if lambda["api_gateway_request_id"] != "c6af9ac6-7b61-11e6-9a41-93e812345678" {
+		t.Errorf("got api gateway request id %v, want the request id of API Gateway", lambda["api_gateway_request_id"])
+	}
+	if lambda["alias"] != "prod" {
+		t.Errorf("got alias %v, want prod", lambda["alias"])
+	}
+	if lambda["cold_start"] != true {
+		t.Errorf("got cold start %v, want true for the first invocation", lambda["cold_start"])
+	}
+	if remaining, _ := lambda["remaining_time_ms"].(int64); remaining <= 0 || remaining > 3000 {
+		t.Errorf("got remaining time %v, want the time left before the deadline", lambda["remaining_time_ms"])
+	}
+}
+
+func TestMoesifLoggerRecordsTheSelectedLambdaContextFields(t *testing.T) {
+	coldStart = false
+	lambda := invokeWithLambdaContext(t, WithLambdaContextFields("aws_request_id", "cold_start"))
+
+	if len(lambda) != 2 || lambda["aws_request_id"] == nil || lambda["cold_start"] != false {
+		t.Errorf("got %v, want only the aws request id and a warm start", lambda)
+	}
+
+	if _, err := NewConfig(nil, WithLambdaContextFields("function_arn")); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
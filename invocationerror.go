@@ -0,0 +1,42 @@
+package moesifawslambda
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// API Gateway, load balancers and Function URLs answer with a 502 when the function fails
+const defaultErrorStatusCode = http.StatusBadGateway
+
+// handlerFailure is an error returned by the handler or a value it panicked with
+type handlerFailure struct {
+	err        error
+	panicked   bool
+	panicValue interface{}
+}
+
+// Propagate the panic recovered by callHandler, once the invocation is logged
+func (f *handlerFailure) repanic() {
+	if f != nil && f.panicked {
+		panic(f.panicValue)
+	}
+}
+
+// The response the client receives instead of the response of the handler
+func (f *handlerFailure) response() (int, map[string]string, string) {
+	return moesifConfig.ErrorStatusCode, map[string]string{"Content-Type": "application/json"}, `{"message": "Internal server error"}`
+}
+
+func (f *handlerFailure) metadata() map[string]interface{} {
+	if f.panicked {
+		return map[string]interface{}{
+			"message": fmt.Sprint(f.panicValue),
+			"type":    fmt.Sprintf("%T", f.panicValue),
+			"panic":   true,
+		}
+	}
+	return map[string]interface{}{
+		"message": f.err.Error(),
+		"type":    fmt.Sprintf("%T", f.err),
+	}
+}
@@ -0,0 +1,66 @@
+package moesifawslambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func errorMetadata(t *testing.T, metadata interface{}) map[string]interface{} {
+	failure, ok := (*metadata.(*map[string]interface{}))["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got metadata %v, want an error entry", metadata)
+	}
+	return failure
+}
+
+func TestMoesifLoggerLogsHandlerErrors(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{}, errors.New("database unavailable")
+	}, MoesifOptions()).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	if _, err := handler(context.Background(), generateProxyReq(nil, false)); err == nil || err.Error() != "database unavailable" {
+		t.Fatalf("got error %v, want the error of the handler", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if sent[0].Response.Status != 502 {
+		t.Errorf("got status %d, want 502", sent[0].Response.Status)
+	}
+	if failure := errorMetadata(t, sent[0].Metadata); failure["message"] != "database unavailable" || failure["type"] != "*errors.errorString" {
+		t.Errorf("got error metadata %v, want the message and type of the error", failure)
+	}
+}
+
+func TestMoesifLoggerLogsHandlerPanicsBeforePropagatingThem(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		panic("nil map")
+	}, MoesifOptions(), WithErrorStatusCode(500)).(func(context.Context, events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error))
+
+	func() {
+		defer func() {
+			if r := recover(); r != "nil map" {
+				t.Errorf("got panic %v, want the panic of the handler", r)
+			}
+		}()
+		handler(context.Background(), generateProxyReqV2HTTP(nil, false))
+	}()
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if sent[0].Response.Status != 500 {
+		t.Errorf("got status %d, want 500", sent[0].Response.Status)
+	}
+	if failure := errorMetadata(t, sent[0].Metadata); failure["message"] != "nil map" || failure["panic"] != true {
+		t.Errorf("got error metadata %v, want the panic value", failure)
+	}
+}
@@ -0,0 +1,73 @@
+package moesifawslambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/moesif/moesif-aws-lambda-go/extension"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+const extensionTimeout = time.Second
+
+// Client posting the events to the Moesif extension. It uses the original default transport
+// so that the events are not captured as outgoing calls.
+var extensionClient = &http.Client{
+	Transport: http.DefaultTransport,
+	Timeout:   extensionTimeout,
+}
+
+// Hand a batch of events to the Moesif extension
+func sendEventsToExtension(batch []*models.EventModel) error {
+	return postToExtension(batch, nil)
+}
+
+// Tell the Moesif extension that the invocation is done so it can send the events
+func notifyExtension(ctx context.Context) {
+	var requestId string
+	if lc, found := lambdacontext.FromContext(ctx); found {
+		requestId = lc.AwsRequestID
+	}
+
+	deliver("notifying the Moesif extension", func() error {
+		return postToExtension([]*models.EventModel{}, http.Header{
+			extension.RequestIdHeader:      {requestId},
+			extension.InvocationDoneHeader: {"true"},
+		})
+	})
+}
+
+func postToExtension(batch []*models.EventModel, header http.Header) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+moesifConfig.ExtensionAddress+extension.EventsPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := extensionClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the Moesif extension responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
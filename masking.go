@@ -0,0 +1,83 @@
+package moesifawslambda
+
+import (
+	"fmt"
+
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// maskingStage removes data from an event before it is queued
+type maskingStage func(models.EventModel) models.EventModel
+
+// The masking pipelines of the incoming and outgoing events
+var (
+	incomingMasking []maskingStage
+	outgoingMasking []maskingStage
+)
+
+// Initialize the masking pipelines, both directions go through the same stages before their own masking callback
+func configureMasking(config *Config) {
+	incomingMasking = config.maskingPipeline(config.MaskEventModel)
+	outgoingMasking = config.maskingPipeline(config.MaskEventModelOutgoing)
+}
+
+func (c *Config) maskingPipeline(mask func(models.EventModel) models.EventModel) []maskingStage {
+	var stages []maskingStage
+	if redaction := c.redaction(); redaction != nil {
+		stages = append(stages, redaction.redact)
+	}
+	if scrubber := c.piiScrubber(); scrubber != nil {
+		stages = append(stages, scrubber.scrub)
+	}
+	if mask != nil {
+		stages = append(stages, mask)
+	}
+	return stages
+}
+
+// Transform the request and response bodies of the event, given with their transfer encoding
+func transformBodies(event models.EventModel, transform func(body interface{}, encoding string) interface{}) models.EventModel {
+	if event.Request.Body != nil {
+		*event.Request.Body = transformEventBody(*event.Request.Body, event.Request.TransferEncoding, transform)
+	}
+	event.Response.Body = transformEventBody(event.Response.Body, event.Response.TransferEncoding, transform)
+	return event
+}
+
+func transformEventBody(body interface{}, encoding *string, transform func(body interface{}, encoding string) interface{}) interface{} {
+	// The body of an incoming response is a pointer to the parsed body
+	if pointer, ok := body.(*interface{}); ok {
+		if pointer != nil {
+			*pointer = transformEventBody(*pointer, encoding, transform)
+		}
+		return pointer
+	}
+	transferEncoding := "json"
+	if encoding != nil {
+		transferEncoding = *encoding
+	}
+	return transform(body, transferEncoding)
+}
+
+// Run the event through the masking stages. An event that can't be masked is dropped rather than sent as is
+func maskEvent(event models.EventModel, stages []maskingStage) (masked models.EventModel, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportError(&DeliveryError{Operation: "masking the event", Err: fmt.Errorf("masking panicked: %v", r)})
+			ok = false
+		}
+	}()
+	for _, stage := range stages {
+		event = stage(event)
+	}
+	return event, true
+}
+
+// Mask the event and queue it, it is sent to Moesif in a batch
+func queueEvent(event models.EventModel, stages []maskingStage) {
+	masked, ok := maskEvent(event, stages)
+	if !ok {
+		return
+	}
+	queue.enqueue(&masked)
+}
@@ -0,0 +1,71 @@
+package moesifawslambda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+func TestOutgoingEventsAreMasked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	resetClient()
+	config, err := NewConfig(MoesifOptions(), WithMaskEventModelOutgoing(func(event models.EventModel) models.EventModel {
+		event.Request.Headers.(http.Header).Del("Authorization")
+		return event
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moesifClient(config)
+
+	client := &http.Client{Transport: &Transport{Transport: http.DefaultTransport}}
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/charges", nil)
+	request.Header.Set("Authorization", "Bearer sk_live_secret")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	response.Body.Close()
+	Flush(context.Background())
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if authorization := sent[0].Request.Headers.(http.Header).Get("Authorization"); authorization != "" {
+		t.Errorf("got authorization header %q, want it masked", authorization)
+	}
+	// The request of the application is left untouched
+	if request.Header.Get("Authorization") == "" {
+		t.Errorf("the masking changed the outgoing request")
+	}
+}
+
+func TestEventsThatCantBeMaskedAreDropped(t *testing.T) {
+	resetClient()
+	var reported error
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions(),
+		WithOnError(func(err error) { reported = err }),
+		WithMaskEventModel(func(event models.EventModel) models.EventModel {
+			panic("unexpected body")
+		})).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	if _, err := handler(context.Background(), generateProxyReq(nil, false)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sent := testAPI.events(); len(sent) != 0 {
+		t.Errorf("got %d events sent, want the event dropped", len(sent))
+	}
+	if reported == nil {
+		t.Errorf("expected the masking error to be reported")
+	}
+}
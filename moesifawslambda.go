@@ -2,9 +2,11 @@ package moesifawslambda
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
 
 	"github.com/aws/aws-lambda-go/events"
 	moesifapi "github.com/moesif/moesifapi-go"
@@ -18,272 +20,411 @@ var (
 	logBody                bool
 	disableCaptureOutgoing bool
 	logBodyOutgoing        bool
-	moesifOption           map[string]interface{}
+	moesifConfig           *Config
+	// Constructor of the Moesif API client, replaced in tests
+	newAPIClient = moesifapi.NewAPI
 )
 
 // Start Capture Outgoing Request
-func StartCaptureOutgoing(configurationOption map[string]interface{}) {
+func StartCaptureOutgoing(configurationOption map[string]interface{}, opts ...Option) {
 	// Call the function to initialize the moesif client and moesif options
 	if apiClient == nil {
-		// Set the Capture_Outoing_Requests to true to capture outgoing request
-		configurationOption["Capture_Outoing_Requests"] = true
-		moesifOption = configurationOption
-		moesifClient(moesifOption)
+		config, err := NewConfig(configurationOption, opts...)
+		if err != nil {
+			log.Printf("Invalid Moesif configuration, outgoing requests are not captured: %s.\n", err.Error())
+			return
+		}
+		moesifClient(config)
 	}
 
 	if debug {
 		log.Println("Start Capturing outgoing requests")
 	}
-	// Enable logBody by default
-	logBodyOutgoing = true
-	// Try to fetch the disableTransactionId from the option
-	if isEnabled, found := moesifOption["Log_Body_Outgoing"].(bool); found {
-		logBodyOutgoing = isEnabled
-	}
+	logBodyOutgoing = moesifConfig.LogBodyOutgoing
 
 	http.DefaultTransport = DefaultTransport
 }
 
 // Function to update User
-func UpdateUser(user *models.UserModel, configurationOption map[string]interface{}) {
-	UpdateUserAsync(user, configurationOption)
+func UpdateUser(user *models.UserModel, configurationOption map[string]interface{}, opts ...Option) {
+	UpdateUserAsync(user, configurationOption, opts...)
 }
 
 // Function to update Users in batch
-func UpdateUsersBatch(users []*models.UserModel, configurationOption map[string]interface{}) {
-	UpdateUsersBatchAsync(users, configurationOption)
+func UpdateUsersBatch(users []*models.UserModel, configurationOption map[string]interface{}, opts ...Option) {
+	UpdateUsersBatchAsync(users, configurationOption, opts...)
 }
 
 // Function to update User
-func UpdateCompany(company *models.CompanyModel, configurationOption map[string]interface{}) {
-	UpdateCompanyAsync(company, configurationOption)
+func UpdateCompany(company *models.CompanyModel, configurationOption map[string]interface{}, opts ...Option) {
+	UpdateCompanyAsync(company, configurationOption, opts...)
 }
 
 // Function to update Users in batch
-func UpdateCompaniesBatch(companies []*models.CompanyModel, configurationOption map[string]interface{}) {
-	UpdateCompaniesBatchAsync(companies, configurationOption)
+func UpdateCompaniesBatch(companies []*models.CompanyModel, configurationOption map[string]interface{}, opts ...Option) {
+	UpdateCompaniesBatchAsync(companies, configurationOption, opts...)
 }
 
 // Initialize the client
-func moesifClient(moesifOption map[string]interface{}) {
+func moesifClient(config *Config) {
 
 	applicationId := os.Getenv("MOESIF_APPLICATION_ID")
-	api := moesifapi.NewAPI(applicationId)
+	api := newAPIClient(applicationId)
 	apiClient = api
+	moesifConfig = config
 
-	//  Disable debug by default
-	debug = false
-	// Try to fetch the debug from the option
-	if isDebug, found := moesifOption["Debug"].(bool); found {
-		debug = isDebug
-	}
+	debug = config.Debug
+	logBody = config.LogBody
+	summarizeBinaryBodies = config.SummarizeBinaryBodies
+	// Validated with the configuration
+	trustedProxies, _ = parseTrustedProxies(config.TrustedProxies)
+	apiVersionPathPattern = regexp.MustCompile(config.ApiVersionPathPattern)
 
-	// Enable logBody by default
-	logBody = true
+	// Initialize the error policy applied when Moesif can't be reached
+	configureErrorHandling(config)
 
-	// Try to fetch the logBody from the option
-	if isEnabled, found := moesifOption["Log_Body"].(bool); found {
-		logBody = isEnabled
+	// Initialize the masking applied to the events before they are queued
+	configureMasking(config)
+
+	// Initialize the queue batching the events
+	sendBatch := sendEventsBatch
+	if config.ExtensionMode {
+		sendBatch = sendEventsToExtension
+	}
+	queue = newEventQueue(config.BatchSize, config.MaxQueueSize, sendBatch)
+
+	// Initialize the cache of the application config used to sample the events
+	remoteConfig = newRemoteCache[appConfig](appConfigURL, applicationId)
+	governanceRules = newRemoteCache[[]governanceRule](governanceRulesURL, applicationId)
+	rateLimitStore = config.RateLimitStore
+	if rateLimitStore == nil {
+		rateLimitStore = NewMemoryRateLimitStore()
 	}
 }
 
+// Initialize the client from the options unless it is already initialized.
+// Returns false if the options are invalid.
+func ensureClient(configurationOption map[string]interface{}, opts []Option) bool {
+	if apiClient != nil {
+		return true
+	}
+	config, err := NewConfig(configurationOption, opts...)
+	if err != nil {
+		log.Printf("Invalid Moesif configuration: %s.\n", err.Error())
+		return false
+	}
+	moesifClient(config)
+	return true
+}
+
+// The user of the event, labelled by the unverified JWT of the request when no verified identity is found
 func getUserId(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse) *string {
+	return orTokenUserId(getVerifiedUserId(request, response), request.Headers)
+}
+
+// The user identified by the callback, Cognito or the authorizer, the only one that is governed and rate limited
+func getVerifiedUserId(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse) *string {
 	var username string
-	if _, found := moesifOption["Identify_User"]; found {
-		username = moesifOption["Identify_User"].(func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string)(request, response)
+	if identifyUser := moesifConfig.APIGatewayProxy.IdentifyUser; identifyUser != nil {
+		username = identifyUser(request, response)
 		return &username
 	} else {
 		if len(request.RequestContext.Identity.CognitoIdentityID) > 0 {
 			return &request.RequestContext.Identity.CognitoIdentityID
 		} else {
-			return nil
+			// Callers authenticated by a Cognito user pool or Lambda authorizer are identified by their claims
+			return claimsUserId(authorizerClaims(request.RequestContext.Authorizer))
 		}
 	}
 }
 
 func getUserIdV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse) *string {
+	return orTokenUserId(getVerifiedUserIdV2HTTP(request, response), request.Headers)
+}
+
+func getVerifiedUserIdV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse) *string {
 	var username string
-	if _, found := moesifOption["Identify_User"]; found {
-		username = moesifOption["Identify_User"].(func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string)(request, response)
+	if identifyUser := moesifConfig.APIGatewayV2HTTP.IdentifyUser; identifyUser != nil {
+		username = identifyUser(request, response)
 		return &username
 	} else {
-		switch (request.RequestContext.Authorizer != nil) && (request.RequestContext.Authorizer.IAM != nil) {
-		case true:
+		if (request.RequestContext.Authorizer != nil) && (request.RequestContext.Authorizer.IAM != nil) {
 			identity := request.RequestContext.Authorizer.IAM
 			if len(identity.CognitoIdentity.IdentityID) > 0 {
 				return &request.RequestContext.Authorizer.IAM.CognitoIdentity.IdentityID
 			}
-		case false:
-			return nil
 		}
-		return nil
+		// Callers authenticated by a JWT or Lambda authorizer are identified by their claims
+		return claimsUserId(authorizerClaimsV2HTTP(request.RequestContext.Authorizer))
 	}
 }
 
-func sendMoesifAsyncV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse, configurationOption map[string]interface{}) {
+// The company of the event, labelled by the unverified JWT of the request when no verified identity is found
+func getCompanyId(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse) string {
+	return orTokenCompanyId(getVerifiedCompanyId(request, response), request.Headers)
+}
 
-	// Api Version
-	var apiVersion *string = nil
-	if isApiVersion, found := moesifOption["Api_Version"].(string); found {
-		apiVersion = &isApiVersion
+// The company identified by the callback, the authorizer or the API key, the only one that is governed and rate limited
+func getVerifiedCompanyId(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse) string {
+	if identifyCompany := moesifConfig.APIGatewayProxy.IdentifyCompany; identifyCompany != nil {
+		return identifyCompany(request, response)
+	}
+	return claimsOrApiKeyCompanyId(authorizerClaims(request.RequestContext.Authorizer), request.RequestContext.Identity)
+}
+
+func getCompanyIdV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse) string {
+	return orTokenCompanyId(getVerifiedCompanyIdV2HTTP(request, response), request.Headers)
+}
+
+func getVerifiedCompanyIdV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse) string {
+	if identifyCompany := moesifConfig.APIGatewayV2HTTP.IdentifyCompany; identifyCompany != nil {
+		return identifyCompany(request, response)
+	}
+	return claimsCompanyId(authorizerClaimsV2HTTP(request.RequestContext.Authorizer))
+}
+
+// Mask the incoming event and queue it unless it is skipped
+func sendIncomingEvent(moesifEvent models.EventModel, shouldSkip bool) {
+	if shouldSkip {
+		if debug {
+			log.Printf("Skip sending the event to Moesif")
+		}
+		return
 	}
 
+	if debug {
+		log.Printf("Sending the event to Moesif")
+	}
+
+	queueEvent(moesifEvent, incomingMasking)
+}
+
+func sendMoesifAsyncV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse, invocation *invocation) {
+	callbacks := moesifConfig.APIGatewayV2HTTP
+
+	// A failed invocation is logged with the response the client receives
+	if invocation.failure != nil {
+		statusCode, headers, body := invocation.failure.response()
+		response = events.APIGatewayV2HTTPResponse{StatusCode: statusCode, Headers: headers, Body: body}
+	}
+
+	// Api Version
+	apiVersion := incomingApiVersion(request.RawPath, request.RequestContext.Stage, request.Headers)
+
 	// Get Metadata
 	var metadata map[string]interface{} = nil
-	if _, found := moesifOption["Get_Metadata"]; found {
-		metadata = moesifOption["Get_Metadata"].(func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) map[string]interface{})(request, response)
+	if callbacks.GetMetadata != nil {
+		metadata = callbacks.GetMetadata(request, response)
 	}
 
+	// Record the failure and the Lambda context of the invocation
+	metadata = withInvocationMetadata(metadata, invocation, request.RequestContext.RequestID)
+
+	// Record the route matched by the request
+	metadata = withRouteMetadata(metadata, routeKeyTemplate(request.RouteKey), request.PathParameters)
+
 	// Get User
 	var userId *string
 	userId = getUserIdV2HTTP(request, response)
 
 	// Get Company
 	var companyId string
-	if _, found := moesifOption["Identify_Company"]; found {
-		companyId = moesifOption["Identify_Company"].(func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string)(request, response)
-	}
+	companyId = getCompanyIdV2HTTP(request, response)
 
 	// Get Session Token
 	var sessionToken string
-	if _, found := moesifOption["Get_Session_Token"]; found {
-		sessionToken = moesifOption["Get_Session_Token"].(func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) string)(request, response)
+	if callbacks.GetSessionToken != nil {
+		sessionToken = callbacks.GetSessionToken(request, response)
+	}
+
+	// Sample the event with the rates of the application config
+	weight, sampled := sampleEvent(userId, companyId)
+	if !sampled {
+		return
 	}
 
 	// Prepare Moesif Event
-	moesifEvent := prepareEventV2HTTP(request, response, apiVersion, userId, companyId, sessionToken, metadata)
+	moesifEvent := prepareEventV2HTTP(request, response, invocation, apiVersion, userId, companyId, sessionToken, metadata)
+	moesifEvent.Weight = &weight
 
 	// Should skip
 	shouldSkip := false
-	if _, found := moesifOption["Should_Skip"]; found {
-		shouldSkip = moesifOption["Should_Skip"].(func(events.APIGatewayV2HTTPRequest, events.APIGatewayV2HTTPResponse) bool)(request, response)
+	if callbacks.ShouldSkip != nil {
+		shouldSkip = callbacks.ShouldSkip(request, response)
 	}
 
-	if shouldSkip {
-		if debug {
-			log.Printf("Skip sending the event to Moesif")
-		}
-	} else {
-		if debug {
-			log.Printf("Sending the event to Moesif")
-		}
-
-		if _, found := moesifOption["Mask_Event_Model"]; found {
-			moesifEvent = moesifOption["Mask_Event_Model"].(func(models.EventModel) models.EventModel)(moesifEvent)
-		}
+	sendIncomingEvent(moesifEvent, shouldSkip)
+}
 
-		// Call the function to send event to Moesif
-		_, err := apiClient.CreateEvent(&moesifEvent)
+func sendMoesifAsync(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse, invocation *invocation) {
+	callbacks := moesifConfig.APIGatewayProxy
 
-		if err != nil {
-			log.Fatalf("Error while sending event to Moesif: %s.\n", err.Error())
-		}
-
-		if debug {
-			log.Printf("Successfully sent event to Moesif")
-		}
+	// A failed invocation is logged with the response the client receives
+	if invocation.failure != nil {
+		statusCode, headers, body := invocation.failure.response()
+		response = events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: body}
 	}
-}
-
-func sendMoesifAsync(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse, configurationOption map[string]interface{}) {
 
 	// Api Version
-	var apiVersion *string = nil
-	if isApiVersion, found := moesifOption["Api_Version"].(string); found {
-		apiVersion = &isApiVersion
-	}
+	apiVersion := incomingApiVersion(request.Path, request.RequestContext.Stage, request.Headers)
 
 	// Get Metadata
 	var metadata map[string]interface{} = nil
-	if _, found := moesifOption["Get_Metadata"]; found {
-		metadata = moesifOption["Get_Metadata"].(func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) map[string]interface{})(request, response)
+	if callbacks.GetMetadata != nil {
+		metadata = callbacks.GetMetadata(request, response)
 	}
 
+	// Record the failure and the Lambda context of the invocation
+	metadata = withInvocationMetadata(metadata, invocation, request.RequestContext.RequestID)
+
+	// Record the route matched by the request
+	metadata = withRouteMetadata(metadata, request.Resource, request.PathParameters)
+
 	// Get User
 	var userId *string
 	userId = getUserId(request, response)
 
 	// Get Company
 	var companyId string
-	if _, found := moesifOption["Identify_Company"]; found {
-		companyId = moesifOption["Identify_Company"].(func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string)(request, response)
-	}
+	companyId = getCompanyId(request, response)
 
 	// Get Session Token
 	var sessionToken string
-	if _, found := moesifOption["Get_Session_Token"]; found {
-		sessionToken = moesifOption["Get_Session_Token"].(func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string)(request, response)
+	if callbacks.GetSessionToken != nil {
+		sessionToken = callbacks.GetSessionToken(request, response)
+	}
+
+	// Sample the event with the rates of the application config
+	weight, sampled := sampleEvent(userId, companyId)
+	if !sampled {
+		return
 	}
 
 	// Prepare Moesif Event
-	moesifEvent := prepareEvent(request, response, apiVersion, userId, companyId, sessionToken, metadata)
+	moesifEvent := prepareEvent(request, response, invocation, apiVersion, userId, companyId, sessionToken, metadata)
+	moesifEvent.Weight = &weight
 
 	// Should skip
 	shouldSkip := false
-	if _, found := moesifOption["Should_Skip"]; found {
-		shouldSkip = moesifOption["Should_Skip"].(func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) bool)(request, response)
+	if callbacks.ShouldSkip != nil {
+		shouldSkip = callbacks.ShouldSkip(request, response)
 	}
 
-	if shouldSkip {
-		if debug {
-			log.Printf("Skip sending the event to Moesif")
-		}
-	} else {
-		if debug {
-			log.Printf("Sending the event to Moesif")
-		}
-
-		if _, found := moesifOption["Mask_Event_Model"]; found {
-			moesifEvent = moesifOption["Mask_Event_Model"].(func(models.EventModel) models.EventModel)(moesifEvent)
-		}
-
-		// Call the function to send event to Moesif
-		_, err := apiClient.CreateEvent(&moesifEvent)
+	sendIncomingEvent(moesifEvent, shouldSkip)
+}
 
-		if err != nil {
-			log.Fatalf("Error while sending event to Moesif: %s.\n", err.Error())
-		}
+// Wrap the handler to log every invocation to Moesif.
+// The configuration is validated at wrap time and MoesifLogger panics if it is invalid.
+func MoesifLogger(f interface{}, configurationOption map[string]interface{}, opts ...Option) interface{} {
+	config, err := NewConfig(configurationOption, opts...)
+	if err != nil {
+		panic("invalid Moesif configuration passed to MoesifLogger: " + err.Error())
+	}
+	handler, err := wrapHandler(f, config)
+	if err != nil {
+		panic(err.Error() + " passed to MoesifLogger")
+	}
+	return handler
+}
 
-		if debug {
-			log.Printf("Successfully sent event to Moesif")
-		}
+// Wrap the handler to log every invocation to Moesif, returning an error if the configuration is invalid
+func NewMoesifLogger(f interface{}, opts ...Option) (interface{}, error) {
+	config, err := NewConfig(nil, opts...)
+	if err != nil {
+		return nil, err
 	}
+	return wrapHandler(f, config)
 }
 
-func MoesifLogger(f interface{}, configurationOption map[string]interface{}) interface{} {
+func wrapHandler(f interface{}, config *Config) (interface{}, error) {
 	switch handler := f.(type) {
 	case func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error):
-		// Handle v1.0 payload
-		return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-			// Initialize the Moesif client if not already initialized
-			if apiClient == nil {
-				moesifOption = configurationOption
-				moesifClient(moesifOption)
-			}
-
-			// Call the handler and send data to Moesif
-			response, err := handler(ctx, request)
-			sendMoesifAsync(request, response, configurationOption)
-			return response, err
+		if err := config.validateFor(payloadAPIGatewayProxy); err != nil {
+			return nil, err
 		}
+		// Handle v1.0 payload
+		return wrapEventHandler(config, handler, governedRequestV1, func(statusCode int, headers map[string]string, body string) events.APIGatewayProxyResponse {
+			return events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: body}
+		}, sendMoesifAsync), nil
 
 	case func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error):
+		if err := config.validateFor(payloadAPIGatewayV2HTTP); err != nil {
+			return nil, err
+		}
 		// Handle v2.0 payload
-		return func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-			// Initialize the Moesif client if not already initialized
-			if apiClient == nil {
-				moesifOption = configurationOption
-				moesifClient(moesifOption)
+		return wrapEventHandler(config, handler, governedRequestV2HTTP, func(statusCode int, headers map[string]string, body string) events.APIGatewayV2HTTPResponse {
+			return events.APIGatewayV2HTTPResponse{StatusCode: statusCode, Headers: headers, Body: body}
+		}, sendMoesifAsyncV2HTTP), nil
+
+	case func(ctx context.Context, request events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error):
+		if err := config.validateFor(payloadALBTargetGroup); err != nil {
+			return nil, err
+		}
+		// Handle Application Load Balancer target group events
+		return wrapEventHandler(config, handler, governedRequestALB, func(statusCode int, headers map[string]string, body string) events.ALBTargetGroupResponse {
+			return events.ALBTargetGroupResponse{
+				StatusCode:        statusCode,
+				StatusDescription: fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+				Headers:           headers,
+				Body:              body,
 			}
+		}, sendMoesifAsyncALB), nil
 
-			// Call the handler and send data to Moesif
-			response, err := handler(ctx, request)
-			sendMoesifAsyncV2HTTP(request, response, configurationOption)
-			return response, err
+	case func(ctx context.Context, request events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error):
+		if err := config.validateFor(payloadFunctionURL); err != nil {
+			return nil, err
+		}
+		// Handle Lambda Function URL events
+		return wrapEventHandler(config, handler, governedRequestFunctionURL, func(statusCode int, headers map[string]string, body string) events.LambdaFunctionURLResponse {
+			return events.LambdaFunctionURLResponse{StatusCode: statusCode, Headers: headers, Body: body}
+		}, sendMoesifAsyncFunctionURL), nil
+
+	case func(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error):
+		if err := config.validateFor(payloadWebsocket); err != nil {
+			return nil, err
 		}
+		// Handle WebSocket API events
+		return wrapEventHandler(config, handler, governedRequestWebsocket, func(statusCode int, headers map[string]string, body string) events.APIGatewayProxyResponse {
+			return events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: body}
+		}, sendMoesifAsyncWebsocket), nil
 
 	default:
-		// Unsupported handler type
-		panic("unsupported handler type passed to MoesifLogger")
+		// Any other handler shape is wrapped as a lambda.Handler
+		return wrapLambdaHandler(f, config)
+	}
+}
+
+// Wrap the handler of an event type, given the governed request of the event, the response of a blocking rule
+// and the sending of the event to Moesif
+func wrapEventHandler[Request, Response any](
+	config *Config,
+	handler func(context.Context, Request) (Response, error),
+	governedRequest func(Request) func() *governedRequest,
+	blockedResponse func(statusCode int, headers map[string]string, body string) Response,
+	send func(Request, Response, *invocation),
+) func(context.Context, Request) (Response, error) {
+	return func(ctx context.Context, request Request) (Response, error) {
+		// Initialize the Moesif client if not already initialized
+		if apiClient == nil {
+			moesifClient(config)
+		}
+
+		// Call the handler unless a governance rule blocks the request, then send data to Moesif.
+		// An error or a panic is logged as the response the client receives
+		var response Response
+		var err error
+		invocation := callGovernedHandler(ctx, governedRequest(request), func() error {
+			response, err = handler(ctx, request)
+			return err
+		})
+		if invocation.blocked() {
+			response = blockedResponse(invocation.governance.response())
+		}
+		send(request, response, invocation)
+
+		// Make sure the events are sent before the execution environment is frozen
+		flushInvocation(ctx)
+		invocation.failure.repanic()
+		return response, err
 	}
 }
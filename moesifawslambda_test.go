@@ -0,0 +1,93 @@
+package moesifawslambda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	moesifapi "github.com/moesif/moesifapi-go"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// recordingAPI is a Moesif API client keeping the events it receives
+type recordingAPI struct {
+	moesifapi.API
+	mutex   sync.Mutex
+	batches [][]*models.EventModel
+}
+
+func (r *recordingAPI) CreateEventsBatch(events []*models.EventModel) (http.Header, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.batches = append(r.batches, events)
+	return http.Header{}, nil
+}
+
+func (r *recordingAPI) events() []*models.EventModel {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var all []*models.EventModel
+	for _, batch := range r.batches {
+		all = append(all, batch...)
+	}
+	return all
+}
+
+// The Moesif API client used by the tests
+var testAPI = &recordingAPI{}
+
+func TestMain(m *testing.M) {
+	// Never send the test events to Moesif
+	newAPIClient = func(applicationId string) moesifapi.API {
+		return testAPI
+	}
+	// Nor fetch the application config from Moesif, every event is sampled
+	configServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"sample_rate": 100}`))
+	}))
+	appConfigURL = configServer.URL
+	code := m.Run()
+	configServer.Close()
+	os.Exit(code)
+}
+
+// Forget the initialized client and the recorded events so the next wrapped handler uses its own options
+func resetClient() {
+	apiClient = nil
+	testAPI.mutex.Lock()
+	testAPI.batches = nil
+	testAPI.mutex.Unlock()
+}
+
+func TestMoesifLoggerTimesTheHandler(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		time.Sleep(20 * time.Millisecond)
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	}, MoesifOptions()).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	// API Gateway received the request before the function was invoked
+	received := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+	request := generateProxyReq(nil, false)
+	request.RequestContext.RequestTimeEpoch = received.UnixNano() / int64(time.Millisecond)
+
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	if !sent[0].Request.Time.Equal(received) {
+		t.Errorf("got request time %v, want the request time of API Gateway %v", sent[0].Request.Time, received)
+	}
+	if duration := sent[0].Response.Time.Sub(*sent[0].Request.Time); duration < time.Second+20*time.Millisecond {
+		t.Errorf("got duration %v, want at least the time since the request was received", duration)
+	}
+}
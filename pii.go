@@ -0,0 +1,197 @@
+package moesifawslambda
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// Actions applied to the PII found in the bodies
+const (
+	// Replace the PII with the Redaction_Placeholder
+	PIIActionRedact = "redact"
+	// Replace the PII with a keyed hash of it, so that equal values can still be correlated
+	PIIActionHash = "hash"
+)
+
+// The key of the hashes when PII_Hash_Key is not set, generated for the instance so that the hashes can't be reversed
+var piiHashKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// Detectors of the PII scrubbed from the bodies
+const (
+	PIIDetectorJWT          = "jwt"
+	PIIDetectorAWSAccessKey = "aws_access_key"
+	PIIDetectorEmail        = "email"
+	PIIDetectorCreditCard   = "credit_card"
+	PIIDetectorSSN          = "ssn"
+	PIIDetectorPhone        = "phone"
+)
+
+// piiDetector finds a kind of PII in a string, the matches are checked with valid when set
+type piiDetector struct {
+	name    string
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}
+
+// The detectors, in the order they are applied so that a token is not mistaken for a shorter kind of PII
+var piiDetectors = []piiDetector{
+	{name: PIIDetectorJWT, pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	{name: PIIDetectorAWSAccessKey, pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[A-Z0-9]{16}\b`)},
+	{name: PIIDetectorEmail, pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{name: PIIDetectorCreditCard, pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: isCreditCardNumber},
+	{name: PIIDetectorSSN, pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), valid: isSSN},
+	{name: PIIDetectorPhone, pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?(?:\(\d{1,4}\)|\d{1,4})[ .-]?\d{3,4}[ .-]?\d{3,4}|\(\d{3}\) ?\d{3}[ .-]\d{4}|\b\d{3}[.-]\d{3}[.-]\d{4})\b`)},
+}
+
+func piiDetectorNames() []string {
+	names := make([]string, len(piiDetectors))
+	for i, detector := range piiDetectors {
+		names[i] = detector.name
+	}
+	return names
+}
+
+// Check the digits of a card number with the Luhn algorithm
+func isCreditCardNumber(match string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(match)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// Social Security Numbers are never issued with an area of 000, 666 or 9xx, a group of 00 or a serial of 0000
+func isSSN(match string) bool {
+	parts := strings.Split(match, "-")
+	area, group, serial := parts[0], parts[1], parts[2]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// piiScrubber replaces the PII found in the string values of the bodies
+type piiScrubber struct {
+	detectors   []piiDetector
+	action      string
+	placeholder string
+	hashKey     []byte
+}
+
+// The PII scrubbing stage of the masking pipeline, nil unless Scrub_PII is enabled
+func (c *Config) piiScrubber() *piiScrubber {
+	if !c.ScrubPII {
+		return nil
+	}
+	enabled := c.PIIDetectors
+	if len(enabled) == 0 {
+		enabled = piiDetectorNames()
+	}
+	s := &piiScrubber{action: c.PIIAction, placeholder: c.RedactionPlaceholder, hashKey: piiHashKey}
+	if c.PIIHashKey != "" {
+		s.hashKey = []byte(c.PIIHashKey)
+	}
+	for _, detector := range piiDetectors {
+		if contains(enabled, detector.name) {
+			s.detectors = append(s.detectors, detector)
+		}
+	}
+	return s
+}
+
+func (s *piiScrubber) scrub(event models.EventModel) models.EventModel {
+	found := map[string]int{}
+	event = transformBodies(event, func(body interface{}, encoding string) interface{} {
+		return s.scrubBody(body, encoding, found)
+	})
+
+	if debug && len(found) > 0 {
+		counts := make([]string, 0, len(found))
+		for name, count := range found {
+			counts = append(counts, fmt.Sprintf("%s=%d", name, count))
+		}
+		sort.Strings(counts)
+		log.Printf("Scrubbed PII from the event: %s", strings.Join(counts, ", "))
+	}
+	return event
+}
+
+// Scrub a parsed JSON body, or the text of a base64 encoded body
+func (s *piiScrubber) scrubBody(body interface{}, encoding string, found map[string]int) interface{} {
+	if text, ok := body.(string); ok && encoding == "base64" {
+		decoded, err := b64.StdEncoding.DecodeString(text)
+		if err != nil || !utf8.Valid(decoded) {
+			// Binary bodies are left as is
+			return body
+		}
+		if scrubbed := s.scrubString(string(decoded), found); scrubbed != string(decoded) {
+			return b64.StdEncoding.EncodeToString([]byte(scrubbed))
+		}
+		return body
+	}
+	return s.scrubValue(body, found)
+}
+
+// Scrub the string values of a parsed JSON value, the parsed bodies belong to the event and are changed in place
+func (s *piiScrubber) scrubValue(value interface{}, found map[string]int) interface{} {
+	switch v := value.(type) {
+	case string:
+		return s.scrubString(v, found)
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = s.scrubValue(child, found)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = s.scrubValue(child, found)
+		}
+	}
+	return value
+}
+
+func (s *piiScrubber) scrubString(value string, found map[string]int) string {
+	for _, detector := range s.detectors {
+		detector := detector
+		value = detector.pattern.ReplaceAllStringFunc(value, func(match string) string {
+			if detector.valid != nil && !detector.valid(match) {
+				return match
+			}
+			found[detector.name]++
+			return s.replacement(match)
+		})
+	}
+	return value
+}
+
+func (s *piiScrubber) replacement(match string) string {
+	if s.action == PIIActionHash {
+		// Keyed so that the small spaces of emails, phone and card numbers can't be searched for the hashed value
+		mac := hmac.New(sha256.New, s.hashKey)
+		mac.Write([]byte(match))
+		return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return s.placeholder
+}
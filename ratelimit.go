@@ -0,0 +1,118 @@
+package moesifawslambda
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultRateLimitWindow = time.Minute
+
+// RateLimitStore counts the requests of the users and companies in the windows of the rate limiter.
+// Instances of the function don't share their memory, a store shared by them, such as DynamoDBRateLimitStore,
+// enforces the limits across all of them
+type RateLimitStore interface {
+	// Increment the count of the key and return the new count. The count can be forgotten once it expires
+	Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error)
+}
+
+// The store of the initialized client
+var rateLimitStore RateLimitStore
+
+// MemoryRateLimitStore counts the requests in the memory of the instance of the function
+type MemoryRateLimitStore struct {
+	mutex  sync.Mutex
+	counts map[string]*rateLimitCount
+}
+
+type rateLimitCount struct {
+	count     int64
+	expiresAt time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counts: make(map[string]*rateLimitCount)}
+}
+
+func (s *MemoryRateLimitStore) Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	count, found := s.counts[key]
+	if !found || !now.Before(count.expiresAt) {
+		// Forget the expired counts once in a while, so that the store doesn't grow with every window
+		if !found && len(s.counts) >= 1024 {
+			for key, count := range s.counts {
+				if !now.Before(count.expiresAt) {
+					delete(s.counts, key)
+				}
+			}
+		}
+		count = &rateLimitCount{expiresAt: expiresAt}
+		s.counts[key] = count
+	}
+	count.count++
+	return count.count, nil
+}
+
+// Count the request in the current window of the identity, returns a decision when it is over the limit.
+// The request is let through when the store fails, the limiter never makes the function unavailable
+func rateLimitIdentity(ctx context.Context, kind string, id string, limit int, now time.Time) *governanceDecision {
+	if limit <= 0 || id == "" {
+		return nil
+	}
+
+	window := moesifConfig.RateLimitWindow
+	start := now.Truncate(window)
+	end := start.Add(window)
+	count, err := rateLimitStore.Increment(ctx, kind+":"+id+":"+strconv.FormatInt(start.Unix(), 10), end)
+	if err != nil {
+		if debug {
+			log.Printf("Error while counting the request of %s %s, the request is not rate limited: %s.\n", kind, id, err.Error())
+		}
+		return nil
+	}
+	if count <= int64(limit) {
+		return nil
+	}
+
+	if debug {
+		log.Printf("The %s %s is over its limit of %d requests", kind, id, limit)
+	}
+	retryAfter := int64((end.Sub(now) + time.Second - 1) / time.Second)
+	return &governanceDecision{rule: governanceRule{
+		Id:    kind + "_rate_limit",
+		Name:  "Rate limit of " + strconv.Itoa(limit) + " requests per " + window.String(),
+		Block: true,
+		Response: governanceResponse{
+			Status: http.StatusTooManyRequests,
+			Headers: map[string]string{
+				"Retry-After": strconv.FormatInt(retryAfter, 10),
+			},
+			Body: []byte(`{"message": "Too Many Requests"}`),
+		},
+	}}
+}
+
+// Count the request for its user and its company, returns a decision when either is over its limit
+func rateLimitRequest(ctx context.Context, request func() *governedRequest) *governanceDecision {
+	if request == nil || (moesifConfig.RateLimitUser <= 0 && moesifConfig.RateLimitCompany <= 0) {
+		return nil
+	}
+	limited := request()
+	if limited == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if limited.userId != nil {
+		if decision := rateLimitIdentity(ctx, "user", *limited.userId, moesifConfig.RateLimitUser, now); decision != nil {
+			return decision
+		}
+	}
+	return rateLimitIdentity(ctx, "company", limited.companyId, moesifConfig.RateLimitCompany, now)
+}
@@ -0,0 +1,152 @@
+package moesifawslambda
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DynamoDBRateLimitStore counts the requests in a DynamoDB table shared by the instances of the function.
+// The table has a string partition key named key; enable its time to live on the expires_at attribute so that
+// the counts of the past windows are deleted. The execution role needs dynamodb:UpdateItem on the table
+type DynamoDBRateLimitStore struct {
+	Table string
+	// The region of the table, AWS_REGION by default
+	Region string
+	// The endpoint of DynamoDB in the region by default, such as http://localhost:8000 for DynamoDB Local
+	Endpoint string
+	// The credentials of the execution role from the environment by default
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Client          *http.Client
+}
+
+func NewDynamoDBRateLimitStore(table string) *DynamoDBRateLimitStore {
+	return &DynamoDBRateLimitStore{
+		Table:           table,
+		Region:          os.Getenv("AWS_REGION"),
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		// The counting delays the invocation, it is given up quickly when DynamoDB can't be reached.
+		// The wrapped transport is used so that the signed calls are never captured as outgoing calls
+		Client: &http.Client{Timeout: time.Second, Transport: DefaultTransport.transport()},
+	}
+}
+
+// An attribute value of DynamoDB
+type dynamoDBValue struct {
+	S string `json:"S,omitempty"`
+	N string `json:"N,omitempty"`
+}
+
+func (s *DynamoDBRateLimitStore) Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+	// Atomically add 1 to the count, creating the item of the window on its first request
+	body, err := json.Marshal(map[string]interface{}{
+		"TableName":                s.Table,
+		"Key":                      map[string]dynamoDBValue{"key": {S: key}},
+		"UpdateExpression":         "ADD #count :one SET #expires_at = if_not_exists(#expires_at, :expires_at)",
+		"ExpressionAttributeNames": map[string]string{"#count": "count", "#expires_at": "expires_at"},
+		"ExpressionAttributeValues": map[string]dynamoDBValue{
+			":one":        {N: "1"},
+			":expires_at": {N: strconv.FormatInt(expiresAt.Unix(), 10)},
+		},
+		"ReturnValues": "UPDATED_NEW",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + s.Region + ".amazonaws.com"
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	request.Header.Set("X-Amz-Target", "DynamoDB_20120810.UpdateItem")
+	signRequest(request, body, "dynamodb", s.Region, s.AccessKeyId, s.SecretAccessKey, s.SessionToken, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		Attributes map[string]dynamoDBValue `json:"Attributes"`
+		Type       string                   `json:"__type"`
+		Message    string                   `json:"message"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("unexpected response of DynamoDB with status %d: %s", response.StatusCode, err.Error())
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DynamoDB failed with status %d: %s %s", response.StatusCode, result.Type, result.Message)
+	}
+	return strconv.ParseInt(result.Attributes["count"].N, 10, 64)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign the request with AWS Signature Version 4. The request has no query string, its path is /
+func signRequest(request *http.Request, body []byte, service string, region string, accessKeyId string, secretAccessKey string, sessionToken string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	request.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	// The host and the X-Amz headers are signed, along with the content type
+	names := []string{"content-type", "host"}
+	values := map[string]string{"content-type": request.Header.Get("Content-Type"), "host": request.URL.Host}
+	for _, name := range []string{"X-Amz-Date", "X-Amz-Security-Token", "X-Amz-Target"} {
+		if value := request.Header.Get(name); value != "" {
+			names = append(names, strings.ToLower(name))
+			values[strings.ToLower(name)] = value
+		}
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(values[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{request.Method, "/", "", canonicalHeaders.String(), signedHeaders, sha256Hex(body)}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyId+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
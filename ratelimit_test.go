@@ -0,0 +1,187 @@
+package moesifawslambda
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestMoesifLoggerRateLimitsUsers(t *testing.T) {
+	resetClient()
+	calls := 0
+	var userId string
+	handler := MoesifLogger(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls++
+		return HandleLambdaEvent(ctx, request)
+	}, MoesifOptions(), WithRateLimitUser(2), WithRateLimitWindow(time.Hour), WithAPIGatewayProxyCallbacks(APIGatewayProxyCallbacks{
+		IdentifyUser: func(events.APIGatewayProxyRequest, events.APIGatewayProxyResponse) string { return userId },
+	})).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	tests := []struct {
+		userId string
+		status int
+	}{
+		{"alice", 200},
+		{"alice", 200},
+		{"alice", 429},
+		{"bob", 200},
+		// Unidentified requests are not limited
+		{"", 200},
+		{"", 200},
+		{"", 200},
+	}
+	for i, test := range tests {
+		userId = test.userId
+		response, err := handler(context.Background(), generateProxyReq(nil, false))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if response.StatusCode != test.status {
+			t.Errorf("got status %d for the request %d of %q, want %d", response.StatusCode, i, test.userId, test.status)
+		}
+		if test.status == 429 {
+			if retryAfter, err := strconv.Atoi(response.Headers["Retry-After"]); err != nil || retryAfter < 1 || retryAfter > 3600 {
+				t.Errorf("got Retry-After %q, want the seconds left in the window", response.Headers["Retry-After"])
+			}
+		}
+	}
+	if calls != len(tests)-1 {
+		t.Errorf("got %d calls of the handler, want %d", calls, len(tests)-1)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != len(tests) {
+		t.Fatalf("got %d events sent, want %d", len(sent), len(tests))
+	}
+	blockedBy, ok := (*sent[2].Metadata.(*map[string]interface{}))["blocked_by"].(map[string]interface{})
+	if !ok || blockedBy["rule_id"] != "user_rate_limit" {
+		t.Errorf("got blocked_by %v, want the rate limit of the user", blockedBy)
+	}
+}
+
+// dynamoDBServer is a stand-in for the UpdateItem action of DynamoDB
+type dynamoDBServer struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+	t      *testing.T
+}
+
+func (s *dynamoDBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.Header.Get("X-Amz-Target") != "DynamoDB_20120810.UpdateItem" || r.Header.Get("X-Amz-Security-Token") != "token" ||
+		!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/dynamodb/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature=") {
+		s.t.Errorf("got an unsigned UpdateItem request with headers %v", r.Header)
+	}
+
+	var update struct {
+		TableName string
+		Key       map[string]dynamoDBValue
+	}
+	json.NewDecoder(r.Body).Decode(&update)
+	if update.TableName != "rate-limits" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type": "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException", "message": "Requested resource not found"}`))
+		return
+	}
+	s.counts[update.Key["key"].S]++
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"Attributes": map[string]dynamoDBValue{"count": {N: strconv.FormatInt(s.counts[update.Key["key"].S], 10)}},
+	})
+}
+
+func TestDynamoDBRateLimitStore(t *testing.T) {
+	server := httptest.NewServer(&dynamoDBServer{counts: make(map[string]int64), t: t})
+	defer server.Close()
+
+	store := &DynamoDBRateLimitStore{Table: "rate-limits", Region: "us-east-1", Endpoint: server.URL, AccessKeyId: "AKID", SecretAccessKey: "secret", SessionToken: "token"}
+	expiresAt := time.Now().Add(time.Minute)
+	for _, expected := range []int64{1, 2, 3} {
+		if count, err := store.Increment(context.Background(), "user:alice:0", expiresAt); err != nil || count != expected {
+			t.Errorf("got count %d and error %v, want %d", count, err, expected)
+		}
+	}
+	if count, err := store.Increment(context.Background(), "user:bob:0", expiresAt); err != nil || count != 1 {
+		t.Errorf("got count %d and error %v for another key, want 1", count, err)
+	}
+
+	store.Table = "missing"
+	if _, err := store.Increment(context.Background(), "user:alice:0", expiresAt); err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Errorf("got error %v, want the error of DynamoDB", err)
+	}
+}
+
+func TestDynamoDBCallsAreNotCaptured(t *testing.T) {
+	server := httptest.NewServer(&dynamoDBServer{counts: make(map[string]int64), t: t})
+	defer server.Close()
+
+	resetClient()
+	StartCaptureOutgoing(MoesifOptions())
+	defer func() { http.DefaultTransport = DefaultTransport.Transport }()
+
+	store := NewDynamoDBRateLimitStore("rate-limits")
+	store.Region, store.Endpoint, store.AccessKeyId, store.SecretAccessKey, store.SessionToken = "us-east-1", server.URL, "AKID", "secret", "token"
+	if _, err := store.Increment(context.Background(), "user:alice:0", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	Flush(context.Background())
+
+	if sent := testAPI.events(); len(sent) != 0 {
+		t.Errorf("got %d events sent for the calls to DynamoDB, want none", len(sent))
+	}
+}
+
+// recordingStore counts the requests in memory and records their keys
+type recordingStore struct {
+	*MemoryRateLimitStore
+	keys []string
+}
+
+func (s *recordingStore) Increment(ctx context.Context, key string, expiresAt time.Time) (int64, error) {
+	s.keys = append(s.keys, key)
+	return s.MemoryRateLimitStore.Increment(ctx, key, expiresAt)
+}
+
+func TestForgedTokensAreNotRateLimited(t *testing.T) {
+	resetClient()
+	store := &recordingStore{MemoryRateLimitStore: NewMemoryRateLimitStore()}
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions(), WithRateLimitUser(1), WithRateLimitWindow(time.Hour), WithRateLimitStore(store)).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	forged := generateProxyReq(nil, false)
+	forged.RequestContext.Identity = events.APIGatewayRequestIdentity{}
+	forged.Headers = map[string]string{"Authorization": "Bearer " + testToken(`{"sub": "victim"}`)}
+	for i := 0; i < 3; i++ {
+		if response, err := handler(context.Background(), forged); err != nil || response.StatusCode != 200 {
+			t.Fatalf("got status %d and error %v for a forged token, want 200", response.StatusCode, err)
+		}
+	}
+	if len(store.keys) != 0 {
+		t.Errorf("got the rate limit keys %v for forged tokens, want none", store.keys)
+	}
+
+	// The quota of the user authenticated by the authorizer is left intact
+	authorized := forged
+	authorized.Headers = nil
+	authorized.RequestContext.Authorizer = map[string]interface{}{"principalId": "victim"}
+	if response, _ := handler(context.Background(), authorized); response.StatusCode != 200 {
+		t.Errorf("got status %d for the first request of the user, want 200", response.StatusCode)
+	}
+	if len(store.keys) != 1 || !strings.HasPrefix(store.keys[0], "user:victim:") {
+		t.Errorf("got the rate limit keys %v, want the key of the user", store.keys)
+	}
+
+	// The events are still labelled by the token
+	sent := testAPI.events()
+	if len(sent) != 4 || sent[0].UserId == nil || *sent[0].UserId != "victim" {
+		t.Errorf("got %d events sent, want the first labelled by the token", len(sent))
+	}
+}
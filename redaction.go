@@ -0,0 +1,256 @@
+package moesifawslambda
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+const defaultRedactionPlaceholder = "[REDACTED]"
+
+// The headers redacted unless Redact_Headers is set
+var defaultRedactedHeaders = []string{"Authorization", "Cookie", "X-Api-Key"}
+
+// Kinds of the steps of a body field selector
+const (
+	selectKey = iota
+	selectIndex
+	selectAll
+)
+
+// selectorStep selects the children of a JSON value, or its descendants when recursive
+type selectorStep struct {
+	kind      int
+	key       string
+	index     int
+	recursive bool
+}
+
+// Parse a JSONPath-style selector such as $.card.number, items[*].token, items[0]['api-key'] or $..password
+func parseSelector(selector string) ([]selectorStep, error) {
+	path := strings.TrimPrefix(strings.TrimSpace(selector), "$")
+	var steps []selectorStep
+	first := true
+	for len(path) > 0 {
+		step := selectorStep{}
+		switch {
+		case strings.HasPrefix(path, ".."):
+			step.recursive = true
+			path = path[2:]
+		case strings.HasPrefix(path, "."):
+			path = path[1:]
+		case strings.HasPrefix(path, "["):
+		case first:
+			// The root can be left out, as in card.number
+		default:
+			return nil, fmt.Errorf("unexpected %q", path)
+		}
+		first = false
+
+		if strings.HasPrefix(path, "[") {
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket in %q", path)
+			}
+			inner := path[1:end]
+			path = path[end+1:]
+			switch {
+			case inner == "*":
+				step.kind = selectAll
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				step.kind, step.key = selectKey, inner[1:len(inner)-1]
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid index %q", inner)
+				}
+				step.kind, step.index = selectIndex, index
+			}
+		} else {
+			end := strings.IndexAny(path, ".[]")
+			if end < 0 {
+				end = len(path)
+			}
+			name := path[:end]
+			path = path[end:]
+			if name == "" {
+				return nil, fmt.Errorf("missing field name")
+			}
+			if name == "*" {
+				step.kind = selectAll
+			} else {
+				step.kind, step.key = selectKey, name
+			}
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no field selected")
+	}
+	return steps, nil
+}
+
+// Replace the values selected by the steps with the placeholder. Parsed bodies belong to the event and are changed in place
+func redactValue(value interface{}, steps []selectorStep, placeholder string) interface{} {
+	if len(steps) == 0 {
+		return placeholder
+	}
+	step := steps[0]
+
+	if step.recursive {
+		// Select from this value, then from every descendant
+		here := step
+		here.recursive = false
+		value = redactValue(value, append([]selectorStep{here}, steps[1:]...), placeholder)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				v[key] = redactValue(child, steps, placeholder)
+			}
+		case []interface{}:
+			for i, child := range v {
+				v[i] = redactValue(child, steps, placeholder)
+			}
+		}
+		return value
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch step.kind {
+		case selectAll:
+			for key, child := range v {
+				v[key] = redactValue(child, steps[1:], placeholder)
+			}
+		case selectKey:
+			if child, found := v[step.key]; found {
+				v[step.key] = redactValue(child, steps[1:], placeholder)
+			}
+		}
+	case []interface{}:
+		switch step.kind {
+		case selectAll:
+			for i, child := range v {
+				v[i] = redactValue(child, steps[1:], placeholder)
+			}
+		case selectIndex:
+			if step.index < len(v) {
+				v[step.index] = redactValue(v[step.index], steps[1:], placeholder)
+			}
+		}
+	}
+	return value
+}
+
+// redaction replaces the configured headers, query parameters and body fields of an event with a placeholder
+type redaction struct {
+	headers     []string
+	queryParams []string
+	bodyFields  [][]selectorStep
+	placeholder string
+}
+
+// The redaction stage of the masking pipeline, nil when nothing is redacted
+func (c *Config) redaction() *redaction {
+	if len(c.RedactHeaders) == 0 && len(c.RedactQueryParams) == 0 && len(c.RedactBodyFields) == 0 {
+		return nil
+	}
+	r := &redaction{headers: c.RedactHeaders, queryParams: c.RedactQueryParams, placeholder: c.RedactionPlaceholder}
+	for _, selector := range c.RedactBodyFields {
+		// The selectors are validated with the configuration
+		steps, _ := parseSelector(selector)
+		r.bodyFields = append(r.bodyFields, steps)
+	}
+	return r
+}
+
+func (r *redaction) redact(event models.EventModel) models.EventModel {
+	event.Request.Uri = r.redactURI(event.Request.Uri)
+	event.Request.Headers = r.redactHeaders(event.Request.Headers)
+	event.Response.Headers = r.redactHeaders(event.Response.Headers)
+	if len(r.bodyFields) > 0 {
+		event = transformBodies(event, func(body interface{}, encoding string) interface{} {
+			return r.redactBody(body)
+		})
+	}
+	return event
+}
+
+func (r *redaction) isRedactedHeader(name string) bool {
+	for _, header := range r.headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact a copy of the headers, the headers of an incoming event are the headers of the request
+func (r *redaction) redactHeaders(headers interface{}) interface{} {
+	if len(r.headers) == 0 {
+		return headers
+	}
+	switch h := headers.(type) {
+	case map[string]string:
+		redacted := make(map[string]string, len(h))
+		for name, value := range h {
+			if r.isRedactedHeader(name) {
+				value = r.placeholder
+			}
+			redacted[name] = value
+		}
+		return redacted
+	case http.Header:
+		return http.Header(r.redactMultiValueHeaders(h))
+	case map[string][]string:
+		return r.redactMultiValueHeaders(h)
+	}
+	return headers
+}
+
+func (r *redaction) redactMultiValueHeaders(headers map[string][]string) map[string][]string {
+	redacted := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		if r.isRedactedHeader(name) {
+			values = []string{r.placeholder}
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+// Redact the values of the query parameters, keeping the URI as built otherwise
+func (r *redaction) redactURI(uri string) string {
+	start := strings.Index(uri, "?")
+	if len(r.queryParams) == 0 || start < 0 {
+		return uri
+	}
+
+	params := strings.Split(uri[start+1:], "&")
+	for i, param := range params {
+		rawName, _, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		for _, redacted := range r.queryParams {
+			if strings.EqualFold(redacted, name) {
+				params[i] = rawName + "=" + url.QueryEscape(r.placeholder)
+				break
+			}
+		}
+	}
+	return uri[:start+1] + strings.Join(params, "&")
+}
+
+// Redact the fields of a parsed JSON body
+func (r *redaction) redactBody(body interface{}) interface{} {
+	for _, steps := range r.bodyFields {
+		body = redactValue(body, steps, r.placeholder)
+	}
+	return body
+}
@@ -0,0 +1,81 @@
+package moesifawslambda
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestParseSelector(t *testing.T) {
+	valid := map[string][]selectorStep{
+		"$.card.number":   {{kind: selectKey, key: "card"}, {kind: selectKey, key: "number"}},
+		"card.number":     {{kind: selectKey, key: "card"}, {kind: selectKey, key: "number"}},
+		"items[*].token":  {{kind: selectKey, key: "items"}, {kind: selectAll}, {kind: selectKey, key: "token"}},
+		"items[0]['x-a']": {{kind: selectKey, key: "items"}, {kind: selectIndex, index: 0}, {kind: selectKey, key: "x-a"}},
+		"$..password":     {{kind: selectKey, key: "password", recursive: true}},
+	}
+	for selector, expected := range valid {
+		steps, err := parseSelector(selector)
+		if err != nil || !reflect.DeepEqual(steps, expected) {
+			t.Errorf("got %v, %v for %q, want %v", steps, err, selector, expected)
+		}
+	}
+
+	for _, selector := range []string{"", "$", "card..", "items[", "items[-1]", "card]"} {
+		if _, err := parseSelector(selector); err == nil {
+			t.Errorf("expected an error for %q", selector)
+		}
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	var body interface{}
+	json.Unmarshal([]byte(`{"user": {"password": "a"}, "items": [{"token": "b", "id": 1}, {"token": "c", "id": 2}], "password": "d"}`), &body)
+
+	for _, selector := range []string{"$..password", "items[*].token"} {
+		steps, _ := parseSelector(selector)
+		body = redactValue(body, steps, "***")
+	}
+
+	var expected interface{}
+	json.Unmarshal([]byte(`{"user": {"password": "***"}, "items": [{"token": "***", "id": 1}, {"token": "***", "id": 2}], "password": "***"}`), &expected)
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("got %v, want %v", body, expected)
+	}
+}
+
+func TestMoesifLoggerRedactsEvents(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEvent, MoesifOptions(),
+		WithRedactQueryParams("foo"),
+		WithRedactBodyFields("$.card.number")).(func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	request := generateProxyReq([]byte(`{"card": {"number": "4242424242424242", "exp": "12/30"}}`), false)
+	request.Headers = map[string]string{"Content-Type": "application/json", "authorization": "Bearer secret"}
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	event := sent[0]
+	if authorization := event.Request.Headers.(map[string]string)["authorization"]; authorization != "[REDACTED]" {
+		t.Errorf("got authorization header %q, want it redacted by default", authorization)
+	}
+	if request.Headers["authorization"] != "Bearer secret" {
+		t.Errorf("the redaction changed the headers of the request")
+	}
+	if !strings.HasSuffix(event.Request.Uri, "?foo=%5BREDACTED%5D") {
+		t.Errorf("got uri %q, want the foo query parameter redacted", event.Request.Uri)
+	}
+	card := (*event.Request.Body).(map[string]interface{})["card"].(map[string]interface{})
+	if card["number"] != "[REDACTED]" || card["exp"] != "12/30" {
+		t.Errorf("got card %v, want only the number redacted", card)
+	}
+}
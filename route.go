@@ -0,0 +1,85 @@
+package moesifawslambda
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Uses of the route template matched by API Gateway, such as /users/{id}
+const (
+	// Record the route template and the path parameters in the route metadata of the event
+	RouteTemplateMetadata = "metadata"
+	// Also replace the path of the URI of the event with the route template, so that the events group by endpoint
+	RouteTemplateURI = "uri"
+	// Leave the events as they are
+	RouteTemplateNone = "none"
+)
+
+// A greedy path variable such as {proxy+}
+var greedyPathVariable = regexp.MustCompile(`\{([^{}]+)\+\}`)
+
+// The path of the route key of an HTTP API or WebSocket API, such as "GET /users/{id}".
+// The $default route has no template
+func routeKeyTemplate(routeKey string) string {
+	if _, path, found := strings.Cut(routeKey, " "); found && strings.HasPrefix(path, "/") {
+		return path
+	}
+	return ""
+}
+
+// Record the route template and the path parameters under the route key of the metadata
+func withRouteMetadata(metadata map[string]interface{}, template string, pathParameters map[string]string) map[string]interface{} {
+	if template == "" || moesifConfig.RouteTemplate == RouteTemplateNone {
+		return metadata
+	}
+	route := map[string]interface{}{"template": template}
+	if len(pathParameters) > 0 {
+		parameters := make(map[string]interface{}, len(pathParameters))
+		for name, value := range pathParameters {
+			parameters[name] = value
+		}
+		route["path_parameters"] = parameters
+	}
+	return withMetadata(metadata, "route", route)
+}
+
+// The route template with its greedy path variables replaced with the path they matched,
+// as a template such as /{proxy+} matches every path of the API
+func expandGreedyPathVariables(template string, pathParameters map[string]string) string {
+	return greedyPathVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		if value, found := pathParameters[greedyPathVariable.FindStringSubmatch(variable)[1]]; found {
+			return value
+		}
+		return variable
+	})
+}
+
+// Replace the path of the URI with the route template when Route_Template is uri.
+// The leading segments of the path that are not part of the route, such as the stage or a base path mapping, are kept
+func routeTemplateURI(uri string, template string, pathParameters map[string]string) string {
+	if template == "" || moesifConfig.RouteTemplate != RouteTemplateURI {
+		return uri
+	}
+
+	// The URI is split by hand so that the braces of the template are not escaped
+	pathStart := 0
+	if schemeEnd := strings.Index(uri, "://"); schemeEnd >= 0 {
+		hostEnd := strings.IndexAny(uri[schemeEnd+3:], "/?")
+		if hostEnd < 0 {
+			hostEnd = len(uri) - schemeEnd - 3
+		}
+		pathStart = schemeEnd + 3 + hostEnd
+	}
+	pathEnd := len(uri)
+	if queryStart := strings.Index(uri[pathStart:], "?"); queryStart >= 0 {
+		pathEnd = pathStart + queryStart
+	}
+
+	path := "/" + strings.TrimPrefix(expandGreedyPathVariables(template, pathParameters), "/")
+	segments := strings.Split(strings.Trim(uri[pathStart:pathEnd], "/"), "/")
+	routeSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if extra := len(segments) - len(routeSegments); extra > 0 {
+		path = "/" + strings.Join(segments[:extra], "/") + path
+	}
+	return uri[:pathStart] + path + uri[pathEnd:]
+}
@@ -0,0 +1,65 @@
+package moesifawslambda
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestRouteTemplateURI(t *testing.T) {
+	resetClient()
+	config, err := NewConfig(MoesifOptions(), WithRouteTemplate(RouteTemplateURI))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	moesifClient(config)
+
+	tests := []struct {
+		uri            string
+		template       string
+		pathParameters map[string]string
+		expected       string
+	}{
+		{"https://api.example.com/users/123?fields=name", "/users/{id}", map[string]string{"id": "123"}, "https://api.example.com/users/{id}?fields=name"},
+		// The stage and base path mappings are kept
+		{"https://api.example.com/prod/users/123/orders/9", "/users/{id}/orders/{order}", nil, "https://api.example.com/prod/users/{id}/orders/{order}"},
+		// Greedy path variables keep the path they matched
+		{"https://api.example.com/files/a/b.txt", "/files/{proxy+}", map[string]string{"proxy": "a/b.txt"}, "https://api.example.com/files/a/b.txt"},
+		{"https://api.example.com/users/123/a/b", "/users/{id}/{proxy+}", map[string]string{"id": "123", "proxy": "a/b"}, "https://api.example.com/users/{id}/a/b"},
+		{"https://api.example.com/", "", nil, "https://api.example.com/"},
+	}
+
+	for _, test := range tests {
+		if uri := routeTemplateURI(test.uri, test.template, test.pathParameters); uri != test.expected {
+			t.Errorf("got %q for %q with %q, want %q", uri, test.uri, test.template, test.expected)
+		}
+	}
+}
+
+func TestMoesifLoggerRecordsRouteTemplate(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEventV2HTTP, MoesifOptions()).(func(context.Context, events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error))
+
+	request := generateProxyReqV2HTTP(nil, false)
+	request.RouteKey = "GET /users/{id}"
+	request.RawPath = "/users/123"
+	request.PathParameters = map[string]string{"id": "123"}
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	expected := map[string]interface{}{"template": "/users/{id}", "path_parameters": map[string]interface{}{"id": "123"}}
+	if route := (*sent[0].Metadata.(*map[string]interface{}))["route"]; !reflect.DeepEqual(route, expected) {
+		t.Errorf("got route %v, want %v", route, expected)
+	}
+	// The URI is left as is by default
+	if uri := sent[0].Request.Uri; uri != "http://localhost/users/123?parameter1=value1&parameter1=value2&parameter2=value" {
+		t.Errorf("got uri %q, want the concrete path", uri)
+	}
+}
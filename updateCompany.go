@@ -6,37 +6,39 @@ import (
 )
 
  // Update Company
- func UpdateCompanyAsync(company *models.CompanyModel, configurationOption map[string]interface{}) {
+ func UpdateCompanyAsync(company *models.CompanyModel, configurationOption map[string]interface{}, opts ...Option) {
 	 
 	// Call the function to initialize the moesif client and moesif options
-	if apiClient == nil {
-		moesifClient(configurationOption)
+	if !ensureClient(configurationOption, opts) {
+		return
 	}
 
 	// Update company profile
-	errUpdateCompany := apiClient.UpdateCompany(company)
-	// Log the message
-	if errUpdateCompany != nil {
-		log.Fatalf("Error while updating company: %s.\n", errUpdateCompany.Error())
-	} else {
-		log.Println("Company updated successfully")
-	}
+	deliverNow("updating company", func() error {
+		errUpdateCompany := apiClient.UpdateCompany(company)
+		// Log the message
+		if errUpdateCompany == nil {
+			log.Println("Company updated successfully")
+		}
+		return errUpdateCompany
+	})
  }
 
  // Update Companies Batch
- func UpdateCompaniesBatchAsync(companies []*models.CompanyModel, configurationOption map[string]interface{}) {
+ func UpdateCompaniesBatchAsync(companies []*models.CompanyModel, configurationOption map[string]interface{}, opts ...Option) {
 	 
 	// Call the function to initialize the moesif client and moesif options
-	if apiClient == nil {
-		moesifClient(configurationOption)
+	if !ensureClient(configurationOption, opts) {
+		return
 	}
 
 	// Update company profiles
-	errUpdateCompaniesBatch := apiClient.UpdateCompaniesBatch(companies)
-	// Log the message
-	if errUpdateCompaniesBatch != nil {
-		log.Fatalf("Error while updating companies in batch: %s.\n", errUpdateCompaniesBatch.Error())
-	} else {
-		log.Println("Companies updated successfully")
-	}
+	deliverNow("updating companies in batch", func() error {
+		errUpdateCompaniesBatch := apiClient.UpdateCompaniesBatch(companies)
+		// Log the message
+		if errUpdateCompaniesBatch == nil {
+			log.Println("Companies updated successfully")
+		}
+		return errUpdateCompaniesBatch
+	})
  }
@@ -7,37 +7,39 @@ import (
 )
 
 // Update User
-func UpdateUserAsync(user *models.UserModel, configurationOption map[string]interface{}) {
+func UpdateUserAsync(user *models.UserModel, configurationOption map[string]interface{}, opts ...Option) {
 	 
 	// Call the function to initialize the moesif client and moesif options
-	if apiClient == nil {
-		moesifClient(configurationOption)
+	if !ensureClient(configurationOption, opts) {
+		return
 	}
 
 	// Update user profile
-	errUpdateUser := apiClient.UpdateUser(user)
-	// Log the message
-	if errUpdateUser != nil {
-		log.Fatalf("Error while updating user: %s.\n", errUpdateUser.Error())
-	} else {
-		log.Println("User updated successfully")
-	}
+	deliverNow("updating user", func() error {
+		errUpdateUser := apiClient.UpdateUser(user)
+		// Log the message
+		if errUpdateUser == nil {
+			log.Println("User updated successfully")
+		}
+		return errUpdateUser
+	})
  }
 
  // Update Users Batch
- func UpdateUsersBatchAsync(users []*models.UserModel, configurationOption map[string]interface{}) {
+ func UpdateUsersBatchAsync(users []*models.UserModel, configurationOption map[string]interface{}, opts ...Option) {
 	 
 	// Call the function to initialize the moesif client and moesif options
-	if apiClient == nil {
-		moesifClient(configurationOption)
+	if !ensureClient(configurationOption, opts) {
+		return
 	}
 
 	// Update user profiles
-	errUpdateUserBatch := apiClient.UpdateUsersBatch(users)
-	// Log the message
-	if errUpdateUserBatch != nil {
-		log.Fatalf("Error while updating users in batch: %s.\n", errUpdateUserBatch.Error())
-	} else {
-		log.Println("Users updated successfully")
-	}
+	deliverNow("updating users in batch", func() error {
+		errUpdateUserBatch := apiClient.UpdateUsersBatch(users)
+		// Log the message
+		if errUpdateUserBatch == nil {
+			log.Println("Users updated successfully")
+		}
+		return errUpdateUserBatch
+	})
  }
\ No newline at end of file
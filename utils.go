@@ -144,141 +144,111 @@ func defaultSourceIpV2HTTP(request events.APIGatewayV2HTTPRequest) *string {
 	}
 }
 
-func prepareEvent(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
+// Payload independent view of an incoming request
+type incomingRequest struct {
+	time     time.Time
+	uri      string
+	verb     string
+	headers  map[string]string
+	ip       *string
+	body     string
+	isBase64 bool
+}
 
-	reqTime := time.Now().UTC()
-	var transformReqBody interface{} = nil
-	var transferEncoding string = "json"
+// Payload independent view of the response returned by the handler
+type incomingResponse struct {
+	time     time.Time
+	status   int
+	headers  map[string]string
+	body     string
+	isBase64 bool
+}
 
-	if logBody && len(request.Body) != 0 {
-		if request.IsBase64Encoded && isBase64String(request.Body) {
-			transformReqBody = request.Body
-			transferEncoding = "base64"
-		} else {
-			transformReqBody, transferEncoding = processBody(request.Body)
+// Transform a body of an incoming event, decompressed according to its Content-Encoding and truncated to Max_Body_Size.
+// Returns the original size of a truncated body
+func transformBody(body string, isBase64 bool, contentType string, contentEncoding string) (interface{}, string, bodyTruncation) {
+	var transformBody interface{} = nil
+	var transferEncoding string = "json"
+	originalSize := notTruncated
+
+	if logBody && len(body) != 0 {
+		rawBody := []byte(body)
+		decoded := false
+		if isBase64 && isBase64String(body) {
+			decodedBody, err := b64.StdEncoding.DecodeString(body)
+			if err != nil {
+				return body, "base64", originalSize
+			}
+			rawBody, decoded = decodedBody, true
 		}
-	}
-
-	var transformReqHeaders = make(map[string][]string)
-	for key, value := range request.Headers {
-		transformReqHeaders[key] = []string{value}
-	}
 
-	eventRequestModel := models.EventRequestModel{
-		Time:             &reqTime,
-		Uri:              prepareRequestURI(request),
-		Verb:             request.HTTPMethod,
-		ApiVersion:       apiVersion,
-		IpAddress:        getClientIp(transformReqHeaders, defaultSourceIp(request)),
-		Headers:          processHeaders(request.Headers),
-		Body:             &transformReqBody,
-		TransferEncoding: &transferEncoding,
-	}
-
-	rspTime := time.Now().UTC()
-
-	var transformRespBody interface{}
-	transferEncoding = "json"
-
-	if logBody && len(response.Body) != 0 {
-		if response.IsBase64Encoded && isBase64String(response.Body) {
-			transformRespBody = response.Body
-			transferEncoding = "base64"
+		if capture := decompressBody(rawBody, contentEncoding, moesifConfig.MaxBodySize); capture != nil {
+			decompressed, _ := capture.body()
+			originalSize = capture.truncation()
+			transformBody, transferEncoding = parseBody(decompressed, contentType)
 		} else {
-			transformRespBody, transferEncoding = processBody(response.Body)
+			kept, truncated := truncateBody(rawBody, moesifConfig.MaxBodySize)
+			if truncated {
+				originalSize.size = int64(len(rawBody))
+			}
+			if decoded && bodyKind(contentType) == bodyUnknown {
+				// Base64 encoded bodies of an unknown type are logged as is
+				transformBody = b64.StdEncoding.EncodeToString(kept)
+				transferEncoding = "base64"
+			} else {
+				transformBody, transferEncoding = parseBody(kept, contentType)
+			}
 		}
 	}
-
-	eventResponseModel := models.EventResponseModel{
-		Time:             &rspTime,
-		Status:           response.StatusCode,
-		IpAddress:        nil,
-		Headers:          processHeaders(response.Headers),
-		Body:             &transformRespBody,
-		TransferEncoding: &transferEncoding,
-	}
-
-	direction := "Incoming"
-	weight := 1
-
-	event := models.EventModel{
-		Request:      eventRequestModel,
-		Response:     eventResponseModel,
-		SessionToken: &sessionToken,
-		Tags:         nil,
-		UserId:       userId,
-		CompanyId:    &companyId,
-		Metadata:     &metadata,
-		Direction:    &direction,
-		Weight:       &weight,
-	}
-	return event
+	return transformBody, transferEncoding, originalSize
 }
 
-func prepareEventV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
-
-	reqTime := time.Now().UTC()
-	var transformReqBody interface{} = nil
-	var transferEncoding string = "json"
+func newIncomingEvent(request incomingRequest, response incomingResponse, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
 
-	if logBody && len(request.Body) != 0 {
-		if request.IsBase64Encoded && isBase64String(request.Body) {
-			transformReqBody = request.Body
-			transferEncoding = "base64"
-		} else {
-			transformReqBody, transferEncoding = processBody(request.Body)
-		}
-	}
+	reqTime := request.time.UTC()
+	transformReqBody, reqTransferEncoding, reqBodySize := transformBody(request.body, request.isBase64, headerValue(request.headers, "Content-Type"), headerValue(request.headers, "Content-Encoding"))
 
 	var transformReqHeaders = make(map[string][]string)
-	for key, value := range request.Headers {
+	for key, value := range request.headers {
 		transformReqHeaders[key] = []string{value}
 	}
 
 	eventRequestModel := models.EventRequestModel{
 		Time:             &reqTime,
-		Uri:              prepareRequestURIV2HTTP(request),
-		Verb:             request.RequestContext.HTTP.Method,
+		Uri:              request.uri,
+		Verb:             request.verb,
 		ApiVersion:       apiVersion,
-		IpAddress:        getClientIp(transformReqHeaders, defaultSourceIpV2HTTP(request)),
-		Headers:          processHeaders(request.Headers),
+		IpAddress:        getClientIp(transformReqHeaders, request.ip),
+		Headers:          processHeaders(request.headers),
 		Body:             &transformReqBody,
-		TransferEncoding: &transferEncoding,
+		TransferEncoding: &reqTransferEncoding,
 	}
 
-	rspTime := time.Now().UTC()
-
-	var transformRespBody interface{}
-	transferEncoding = "json"
-
-	if logBody && len(response.Body) != 0 {
-		if response.IsBase64Encoded && isBase64String(response.Body) {
-			transformRespBody = response.Body
-			transferEncoding = "base64"
-		} else {
-			transformRespBody, transferEncoding = processBody(response.Body)
-		}
-	}
+	rspTime := response.time.UTC()
+	transformRespBody, respTransferEncoding, respBodySize := transformBody(response.body, response.isBase64, headerValue(response.headers, "Content-Type"), headerValue(response.headers, "Content-Encoding"))
 
 	eventResponseModel := models.EventResponseModel{
 		Time:             &rspTime,
-		Status:           response.StatusCode,
+		Status:           response.status,
 		IpAddress:        nil,
-		Headers:          processHeaders(response.Headers),
+		Headers:          processHeaders(response.headers),
 		Body:             &transformRespBody,
-		TransferEncoding: &transferEncoding,
+		TransferEncoding: &respTransferEncoding,
 	}
 
+	// Record the original size of the truncated bodies
+	metadata = withTruncatedBodies(metadata, reqBodySize, respBodySize)
+
 	direction := "Incoming"
 	weight := 1
 
 	event := models.EventModel{
 		Request:      eventRequestModel,
 		Response:     eventResponseModel,
-		SessionToken: &sessionToken,
+		SessionToken: nonEmpty(&sessionToken),
 		Tags:         nil,
-		UserId:       userId,
-		CompanyId:    &companyId,
+		UserId:       nonEmpty(userId),
+		CompanyId:    nonEmpty(&companyId),
 		Metadata:     &metadata,
 		Direction:    &direction,
 		Weight:       &weight,
@@ -286,6 +256,42 @@ func prepareEventV2HTTP(request events.APIGatewayV2HTTPRequest, response events.
 	return event
 }
 
+func prepareEvent(request events.APIGatewayProxyRequest, response events.APIGatewayProxyResponse, invocation *invocation, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
+	return newIncomingEvent(incomingRequest{
+		time:     invocation.requestTime(request.RequestContext.RequestTimeEpoch),
+		uri:      routeTemplateURI(prepareRequestURI(request), request.Resource, request.PathParameters),
+		verb:     request.HTTPMethod,
+		headers:  request.Headers,
+		ip:       defaultSourceIp(request),
+		body:     request.Body,
+		isBase64: request.IsBase64Encoded,
+	}, incomingResponse{
+		time:     invocation.end,
+		status:   response.StatusCode,
+		headers:  response.Headers,
+		body:     response.Body,
+		isBase64: response.IsBase64Encoded,
+	}, apiVersion, userId, companyId, sessionToken, metadata)
+}
+
+func prepareEventV2HTTP(request events.APIGatewayV2HTTPRequest, response events.APIGatewayV2HTTPResponse, invocation *invocation, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
+	return newIncomingEvent(incomingRequest{
+		time:     invocation.requestTime(request.RequestContext.TimeEpoch),
+		uri:      routeTemplateURI(prepareRequestURIV2HTTP(request), routeKeyTemplate(request.RouteKey), request.PathParameters),
+		verb:     request.RequestContext.HTTP.Method,
+		headers:  request.Headers,
+		ip:       defaultSourceIpV2HTTP(request),
+		body:     request.Body,
+		isBase64: request.IsBase64Encoded,
+	}, incomingResponse{
+		time:     invocation.end,
+		status:   response.StatusCode,
+		headers:  response.Headers,
+		body:     response.Body,
+		isBase64: response.IsBase64Encoded,
+	}, apiVersion, userId, companyId, sessionToken, metadata)
+}
+
 // Send Outgoing Event to Moesif
 func sendMoesifOutgoingAsync(request *http.Request, reqTime time.Time, apiVersion *string, reqBody interface{}, reqEncoding *string,
 	rspTime time.Time, respStatus int, respHeader http.Header, respBody interface{}, respEncoding *string, userId *string,
@@ -294,14 +300,14 @@ func sendMoesifOutgoingAsync(request *http.Request, reqTime time.Time, apiVersio
 	// Get Client Ip
 	ip := getClientIp(request.Header, nil)
 
-	// Prepare request model
+	// Prepare request model, the headers are copied so that masking them leaves the request of the application untouched
 	event_request := models.EventRequestModel{
 		Time:             &reqTime,
 		Uri:              request.URL.Scheme + "://" + request.Host + request.URL.Path,
 		Verb:             request.Method,
 		ApiVersion:       apiVersion,
 		IpAddress:        ip,
-		Headers:          request.Header,
+		Headers:          request.Header.Clone(),
 		Body:             &reqBody,
 		TransferEncoding: reqEncoding,
 	}
@@ -311,7 +317,7 @@ func sendMoesifOutgoingAsync(request *http.Request, reqTime time.Time, apiVersio
 		Time:             &rspTime,
 		Status:           respStatus,
 		IpAddress:        nil,
-		Headers:          respHeader,
+		Headers:          respHeader.Clone(),
 		Body:             respBody,
 		TransferEncoding: respEncoding,
 	}
@@ -320,24 +326,31 @@ func sendMoesifOutgoingAsync(request *http.Request, reqTime time.Time, apiVersio
 	event := models.EventModel{
 		Request:      event_request,
 		Response:     event_response,
-		SessionToken: sessionToken,
+		SessionToken: nonEmpty(sessionToken),
 		Tags:         nil,
-		UserId:       userId,
-		CompanyId:    companyId,
+		UserId:       nonEmpty(userId),
+		CompanyId:    nonEmpty(companyId),
 		Metadata:     metadata,
 		Direction:    direction,
 		Weight:       weight,
 	}
 
-	// Send event to moesif
-	_, err := apiClient.CreateEvent(&event)
+	queueEvent(event, outgoingMasking)
+}
 
-	// Log the message
-	if err != nil {
-		log.Fatalf("Error while sending event to Moesif: %s.\n", err.Error())
+// The value, nil when it is not set, so that Moesif doesn't record an empty user, company or session
+func nonEmpty(value *string) *string {
+	if value == nil || *value == "" {
+		return nil
 	}
+	return value
+}
 
-	if debug {
-		log.Printf("Successfully sent outgoing event to Moesif")
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
 }
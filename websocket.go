@@ -0,0 +1,200 @@
+package moesifawslambda
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+	models "github.com/moesif/moesifapi-go/models"
+)
+
+// The route key becomes the path of the URI so that each route is its own endpoint,
+// e.g. wss://abcdef.execute-api.us-east-1.amazonaws.com/production/$connect
+func prepareRequestURIWebsocket(request events.APIGatewayWebsocketProxyRequest) string {
+	uri := "wss://"
+
+	if len(request.RequestContext.DomainName) > 0 {
+		uri += request.RequestContext.DomainName
+	} else if hostHeader, found := request.Headers["Host"]; found {
+		uri += hostHeader
+	} else {
+		uri += "localhost"
+	}
+
+	if len(request.RequestContext.Stage) > 0 {
+		uri += "/" + request.RequestContext.Stage
+	}
+	uri += "/" + request.RequestContext.RouteKey
+
+	// Query string parameters are only sent with $connect
+	if len(request.MultiValueQueryStringParameters) > 0 {
+		queryString := ""
+		for q, l := range request.MultiValueQueryStringParameters {
+			for _, v := range l {
+				if queryString != "" {
+					queryString += "&"
+				}
+				queryString += url.QueryEscape(q) + "=" + url.QueryEscape(v)
+			}
+		}
+		uri += "?" + queryString
+	} else if len(request.QueryStringParameters) > 0 {
+		queryString := ""
+		for q := range request.QueryStringParameters {
+			if queryString != "" {
+				queryString += "&"
+			}
+			queryString += url.QueryEscape(q) + "=" + url.QueryEscape(request.QueryStringParameters[q])
+		}
+		uri += "?" + queryString
+	}
+	return uri
+}
+
+// The HTTP verb of the event: the GET upgrading a connection, POST for its messages and its disconnection.
+// The event type and the route key are recorded in the metadata
+func verbWebsocket(request events.APIGatewayWebsocketProxyRequest) string {
+	if len(request.HTTPMethod) > 0 {
+		return request.HTTPMethod
+	}
+	if request.RequestContext.EventType == "CONNECT" {
+		return http.MethodGet
+	}
+	return http.MethodPost
+}
+
+func defaultSourceIpWebsocket(request events.APIGatewayWebsocketProxyRequest) *string {
+	if len(request.RequestContext.Identity.SourceIP) > 0 {
+		return &request.RequestContext.Identity.SourceIP
+	} else {
+		return nil
+	}
+}
+
+// Describe the WebSocket connection and route in the metadata of the event
+func websocketMetadata(request events.APIGatewayWebsocketProxyRequest) map[string]interface{} {
+	websocket := map[string]interface{}{
+		"route_key":     request.RequestContext.RouteKey,
+		"connection_id": request.RequestContext.ConnectionID,
+		"event_type":    request.RequestContext.EventType,
+	}
+	if len(request.RequestContext.MessageDirection) > 0 {
+		websocket["message_direction"] = request.RequestContext.MessageDirection
+	}
+	if request.RequestContext.MessageID != nil {
+		websocket["message_id"] = request.RequestContext.MessageID
+	}
+	if request.RequestContext.ConnectedAt > 0 {
+		websocket["connected_at"] = request.RequestContext.ConnectedAt
+	}
+	if request.RequestContext.DisconnectStatusCode > 0 {
+		websocket["disconnect_status_code"] = request.RequestContext.DisconnectStatusCode
+	}
+	if request.RequestContext.DisconnectReason != nil {
+		websocket["disconnect_reason"] = *request.RequestContext.DisconnectReason
+	}
+	return websocket
+}
+
+func getUserIdWebsocket(request events.APIGatewayWebsocketProxyRequest, response events.APIGatewayProxyResponse) *string {
+	return orTokenUserId(getVerifiedUserIdWebsocket(request, response), request.Headers)
+}
+
+func getVerifiedUserIdWebsocket(request events.APIGatewayWebsocketProxyRequest, response events.APIGatewayProxyResponse) *string {
+	var username string
+	if identifyUser := moesifConfig.APIGatewayWebsocket.IdentifyUser; identifyUser != nil {
+		username = identifyUser(request, response)
+		return &username
+	} else {
+		if len(request.RequestContext.Identity.CognitoIdentityID) > 0 {
+			return &request.RequestContext.Identity.CognitoIdentityID
+		} else {
+			// Callers authenticated by a Lambda authorizer are identified by their claims
+			return claimsUserId(authorizerClaims(request.RequestContext.Authorizer))
+		}
+	}
+}
+
+func getCompanyIdWebsocket(request events.APIGatewayWebsocketProxyRequest, response events.APIGatewayProxyResponse) string {
+	return orTokenCompanyId(getVerifiedCompanyIdWebsocket(request, response), request.Headers)
+}
+
+func getVerifiedCompanyIdWebsocket(request events.APIGatewayWebsocketProxyRequest, response events.APIGatewayProxyResponse) string {
+	if identifyCompany := moesifConfig.APIGatewayWebsocket.IdentifyCompany; identifyCompany != nil {
+		return identifyCompany(request, response)
+	}
+	return claimsOrApiKeyCompanyId(authorizerClaims(request.RequestContext.Authorizer), request.RequestContext.Identity)
+}
+
+func prepareEventWebsocket(request events.APIGatewayWebsocketProxyRequest, response events.APIGatewayProxyResponse, invocation *invocation, apiVersion *string, userId *string, companyId string, sessionToken string, metadata map[string]interface{}) models.EventModel {
+	return newIncomingEvent(incomingRequest{
+		time:     invocation.requestTime(request.RequestContext.RequestTimeEpoch),
+		uri:      prepareRequestURIWebsocket(request),
+		verb:     verbWebsocket(request),
+		headers:  request.Headers,
+		ip:       defaultSourceIpWebsocket(request),
+		body:     request.Body,
+		isBase64: request.IsBase64Encoded,
+	}, incomingResponse{
+		time:     invocation.end,
+		status:   response.StatusCode,
+		headers:  response.Headers,
+		body:     response.Body,
+		isBase64: response.IsBase64Encoded,
+	}, apiVersion, userId, companyId, sessionToken, metadata)
+}
+
+func sendMoesifAsyncWebsocket(request events.APIGatewayWebsocketProxyRequest, response events.APIGatewayProxyResponse, invocation *invocation) {
+	callbacks := moesifConfig.APIGatewayWebsocket
+
+	// A failed invocation is logged with the response the client receives
+	if invocation.failure != nil {
+		statusCode, headers, body := invocation.failure.response()
+		response = events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: body}
+	}
+
+	// Api Version
+	apiVersion := incomingApiVersion("", request.RequestContext.Stage, request.Headers)
+
+	// Get Metadata, with the connection and route unless the callback already sets them
+	var metadata map[string]interface{} = nil
+	if callbacks.GetMetadata != nil {
+		metadata = callbacks.GetMetadata(request, response)
+	}
+	metadata = withMetadata(metadata, "websocket", websocketMetadata(request))
+
+	// Record the failure and the Lambda context of the invocation
+	metadata = withInvocationMetadata(metadata, invocation, request.RequestContext.RequestID)
+
+	// Get User
+	var userId *string
+	userId = getUserIdWebsocket(request, response)
+
+	// Get Company
+	var companyId string
+	companyId = getCompanyIdWebsocket(request, response)
+
+	// Get Session Token, the messages of a connection are tied together by default
+	sessionToken := request.RequestContext.ConnectionID
+	if callbacks.GetSessionToken != nil {
+		sessionToken = callbacks.GetSessionToken(request, response)
+	}
+
+	// Sample the event with the rates of the application config
+	weight, sampled := sampleEvent(userId, companyId)
+	if !sampled {
+		return
+	}
+
+	// Prepare Moesif Event
+	moesifEvent := prepareEventWebsocket(request, response, invocation, apiVersion, userId, companyId, sessionToken, metadata)
+	moesifEvent.Weight = &weight
+
+	// Should skip
+	shouldSkip := false
+	if callbacks.ShouldSkip != nil {
+		shouldSkip = callbacks.ShouldSkip(request, response)
+	}
+
+	sendIncomingEvent(moesifEvent, shouldSkip)
+}
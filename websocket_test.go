@@ -0,0 +1,64 @@
+package moesifawslambda
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func HandleLambdaEventWebsocket(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+// Generates mock `events.APIGatewayWebsocketProxyRequest` message events sent on a connection.
+func generateWebsocketReq(body []byte) events.APIGatewayWebsocketProxyRequest {
+	return events.APIGatewayWebsocketProxyRequest{
+		Body: string(body),
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			Stage:            "production",
+			RouteKey:         "sendMessage",
+			EventType:        "MESSAGE",
+			MessageDirection: "IN",
+			ConnectionID:     "L0SM9cOFvHcCIhw=",
+			DomainName:       "abcdef.execute-api.us-east-1.amazonaws.com",
+			Identity:         events.APIGatewayRequestIdentity{SourceIP: "72.12.164.125"},
+		},
+	}
+}
+
+func TestPrepareRequestURIWebsocket(t *testing.T) {
+	var uri = prepareRequestURIWebsocket(generateWebsocketReq(nil))
+
+	var expected = "wss://abcdef.execute-api.us-east-1.amazonaws.com/production/sendMessage"
+
+	if uri != expected {
+		t.Errorf("got %v, want %v", uri, expected)
+	}
+}
+
+func TestMoesifLoggerWebsocket(t *testing.T) {
+	resetClient()
+	handler := MoesifLogger(HandleLambdaEventWebsocket, MoesifOptions()).(func(context.Context, events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error))
+
+	if _, err := handler(context.Background(), generateWebsocketReq([]byte(`{"action": "sendMessage"}`))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := testAPI.events()
+	if len(sent) != 1 {
+		t.Fatalf("got %d events sent, want 1", len(sent))
+	}
+	event := sent[0]
+	if event.Request.Verb != "POST" {
+		t.Errorf("got verb %q, want POST for a message", event.Request.Verb)
+	}
+	if event.SessionToken == nil || *event.SessionToken != "L0SM9cOFvHcCIhw=" {
+		t.Errorf("got session token %v, want the connection id", event.SessionToken)
+	}
+	metadata := *event.Metadata.(*map[string]interface{})
+	websocket, _ := metadata["websocket"].(map[string]interface{})
+	if websocket["route_key"] != "sendMessage" || websocket["connection_id"] != "L0SM9cOFvHcCIhw=" || websocket["event_type"] != "MESSAGE" {
+		t.Errorf("got websocket metadata %v, want the route key, connection id and event type", websocket)
+	}
+}